@@ -0,0 +1,182 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	libconsole "github.com/ergochat/ircdog/console"
+	"github.com/ergochat/ircdog/lib"
+)
+
+const (
+	breakForward = iota
+	breakDrop
+)
+
+// pausedLine is a line held at a breakpoint, waiting for the user to decide
+// what to do with it. Only the relay goroutine for its direction is blocked.
+type pausedLine struct {
+	connectionID uint64
+	line         string
+	toServer     bool
+	breakpoint   *lib.Breakpoint
+	// buffered, so that answering never blocks the console
+	decision chan breakDecision
+}
+
+type breakDecision struct {
+	action int
+	line   string
+}
+
+// breakpointManager tracks breakpoints and the lines paused at them.
+// Paused lines are answered one at a time, in the order they arrived.
+type breakpointManager struct {
+	sync.Mutex
+	breakpoints []*lib.Breakpoint
+	paused      []*pausedLine
+	// if the console is closed, no one can answer, so nothing is paused
+	consoleClosed bool
+}
+
+func newBreakpointManager(breakpoints []lib.Breakpoint) *breakpointManager {
+	result := new(breakpointManager)
+	for i := range breakpoints {
+		result.breakpoints = append(result.breakpoints, &breakpoints[i])
+	}
+	return result
+}
+
+// pause blocks until the user decides what to do with a line, if it matches
+// a breakpoint. It returns the line to forward, or ok=false if the line was
+// dropped or the connection was closed in the meantime.
+func (b *breakpointManager) pause(connectionID uint64, line string, toServer bool, closed <-chan struct{}) (result string, ok bool) {
+	b.Lock()
+	var breakpoint *lib.Breakpoint
+	if !b.consoleClosed {
+		for _, candidate := range b.breakpoints {
+			if candidate.Match(line, toServer) != nil {
+				breakpoint = candidate
+				break
+			}
+		}
+	}
+	if breakpoint == nil {
+		b.Unlock()
+		return line, true
+	}
+	paused := &pausedLine{
+		connectionID: connectionID,
+		line:         line,
+		toServer:     toServer,
+		breakpoint:   breakpoint,
+		decision:     make(chan breakDecision, 1),
+	}
+	b.paused = append(b.paused, paused)
+	if len(b.paused) == 1 {
+		b.prompt()
+	}
+	b.Unlock()
+
+	select {
+	case decision := <-paused.decision:
+		return decision.line, decision.action == breakForward
+	case <-closed:
+		b.Lock()
+		defer b.Unlock()
+		if b.remove(paused) && len(b.paused) != 0 {
+			b.prompt()
+		}
+		return "", false
+	}
+}
+
+// answer interprets a line typed at the console as the answer for the
+// oldest paused line. It returns false if there are no paused lines.
+func (b *breakpointManager) answer(console libconsole.Console, input string) bool {
+	b.Lock()
+	if len(b.paused) == 0 {
+		b.Unlock()
+		return false
+	}
+	paused := b.paused[0]
+	b.Unlock()
+
+	decision := breakDecision{action: breakForward, line: paused.line}
+	switch strings.ToLower(strings.TrimSpace(input)) {
+	case "f", "forward":
+	case "e", "edit":
+		log.Printf("** ircdog edit the line, then press enter to forward it:")
+		edited, err := libconsole.ReadlineWithDefault(console, paused.line)
+		if err != nil {
+			log.Printf("** ircdog couldn't read edited line: %v", err)
+			// forward the original line
+		} else if decision.line = strings.TrimRight(edited, "\r\n"); decision.line == "" {
+			decision.action = breakDrop
+			log.Printf("** ircdog dropped line")
+		}
+	case "d", "drop":
+		decision.action = breakDrop
+		log.Printf("** ircdog dropped line")
+	case "r", "remove":
+		b.Lock()
+		for i, breakpoint := range b.breakpoints {
+			if breakpoint == paused.breakpoint {
+				b.breakpoints = append(b.breakpoints[:i], b.breakpoints[i+1:]...)
+				log.Printf("** ircdog removed breakpoint `%s`", breakpoint.Text)
+				break
+			}
+		}
+		b.Unlock()
+	default:
+		b.Lock()
+		b.prompt()
+		b.Unlock()
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	if b.remove(paused) {
+		paused.decision <- decision
+		if len(b.paused) != 0 {
+			b.prompt()
+		}
+	}
+	return true
+}
+
+// closeConsole forwards all paused lines, since there's no one to answer.
+func (b *breakpointManager) closeConsole() {
+	b.Lock()
+	defer b.Unlock()
+	b.consoleClosed = true
+	for _, paused := range b.paused {
+		paused.decision <- breakDecision{action: breakForward, line: paused.line}
+	}
+	b.paused = nil
+}
+
+// remove must be called with the mutex held; it returns false if the line
+// was already removed.
+func (b *breakpointManager) remove(paused *pausedLine) bool {
+	for i, candidate := range b.paused {
+		if candidate == paused {
+			b.paused = append(b.paused[:i], b.paused[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// prompt must be called with the mutex held.
+func (b *breakpointManager) prompt() {
+	paused := b.paused[0]
+	direction := "client"
+	if !paused.toServer {
+		direction = "server"
+	}
+	log.Printf("** ircdog breakpoint `%s` paused line from %s %d: %s", paused.breakpoint.Text, direction, paused.connectionID, paused.line)
+	log.Printf("** ircdog (f)orward, (e)dit, (d)rop, or (r)emove breakpoint and forward?")
+}
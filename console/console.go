@@ -12,6 +12,10 @@ import (
 	"github.com/ergochat/ircdog/lib"
 )
 
+const (
+	DefaultPrompt = ">>> "
+)
+
 // Console is an abstract representation of keyboard input and screen output
 type Console interface {
 	io.Writer
@@ -44,3 +48,19 @@ func (s *stdioConsole) Write(b []byte) (n int, err error) {
 func (s *stdioConsole) Close() error {
 	return nil
 }
+
+// ReadlineWithDefault reads a line, prefilled with a default value for the
+// user to edit, if the console supports that.
+func ReadlineWithDefault(c Console, defaultValue string) (string, error) {
+	if prefiller, ok := c.(interface{ SetDefault(string) }); ok {
+		prefiller.SetDefault(defaultValue)
+	}
+	return c.Readline()
+}
+
+// SetPrompt changes the prompt, if the console has one.
+func SetPrompt(c Console, prompt string) {
+	if prompter, ok := c.(interface{ SetPrompt(string) }); ok {
+		prompter.SetPrompt(prompt)
+	}
+}
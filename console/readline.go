@@ -14,7 +14,7 @@ func NewConsole(enableReadline bool, historyFile string) (Console, error) {
 		return NewStandardConsole()
 	}
 	return readline.NewFromConfig(&readline.Config{
-		Prompt:       ">>> ",
+		Prompt:       DefaultPrompt,
 		HistoryFile:  historyFile,
 		HistoryLimit: 1000,
 	})
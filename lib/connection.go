@@ -4,8 +4,14 @@
 package lib
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type ConnectionConfig struct {
@@ -18,6 +24,100 @@ type ConnectionConfig struct {
 	TLSConfig    *tls.Config
 	// Origin header for websockets
 	Origin string
+	// optional SOCKS5 or HTTP CONNECT proxy
+	Proxy *ProxyConfig
+	// optional PROXY protocol header to send after connecting
+	ProxyHeader *ProxyHeaderConfig
+	// optional local address to connect from (a port of 0 means any), and
+	// IP version to connect over (4, 6, or 0 for either); with a Proxy, these
+	// apply to the connection to the proxy
+	BindAddr  *net.TCPAddr
+	IPVersion int
+	// if nonzero, limits on establishing the connection: DialTimeout for the
+	// TCP connection (including any proxy), HandshakeTimeout for the TLS or
+	// WebSocket handshake
+	DialTimeout      time.Duration
+	HandshakeTimeout time.Duration
+	// if nonzero, the connection fails if no line is received for ReadTimeout,
+	// or sending a line takes longer than WriteTimeout
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// TimeoutError is returned when one of the ConnectionConfig timeouts expires,
+// so that timeouts can be told apart from other connection errors.
+type TimeoutError struct {
+	// what timed out, e.g. "read" or "TLS handshake"
+	Operation string
+	Duration  time.Duration
+	Err       error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timeout (%v)", e.Operation, e.Duration)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// wrapTimeout returns a TimeoutError if err is due to a timeout, otherwise err.
+func wrapTimeout(err error, operation string, timeout time.Duration) error {
+	var netErr net.Error
+	if timeout != 0 && (errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())) {
+		return &TimeoutError{Operation: operation, Duration: timeout, Err: err}
+	}
+	return err
+}
+
+// deadline returns the time when a timeout will expire, or the zero time
+// (meaning no deadline) if timeout is 0.
+func deadline(timeout time.Duration) time.Time {
+	if timeout == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// ParseTCPAddr parses an IP address with an optional port, like "203.0.113.5"
+// or "[2001:db8::1]:4000"; the port defaults to 0.
+func ParseTCPAddr(address string) (*net.TCPAddr, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		host, portStr = strings.Trim(address, "[]"), "0"
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.Atoi(portStr)
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid address `%s` (expected an IP address, optionally with a port)", address)
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// dial makes the TCP (or Unix socket) connection, through the proxy if any.
+func (config *ConnectionConfig) dial(network, address string) (conn net.Conn, err error) {
+	dialer := &net.Dialer{Timeout: config.DialTimeout}
+	if network == "tcp" {
+		switch config.IPVersion {
+		case 4:
+			network = "tcp4"
+		case 6:
+			network = "tcp6"
+		}
+		if config.BindAddr != nil {
+			dialer.LocalAddr = config.BindAddr
+		}
+	}
+	if config.Proxy != nil {
+		conn, err = config.Proxy.DialWith(dialer, network, address)
+	} else {
+		conn, err = dialer.Dial(network, address)
+	}
+	return conn, wrapTimeout(err, "connect", config.DialTimeout)
 }
 
 // IRCConnection is an abstract IRC connection.
@@ -34,12 +134,12 @@ type IRCConnection interface {
 
 func NewConnection(config ConnectionConfig) (conn IRCConnection, err error) {
 	if config.WebsocketURL == "" {
-		conn, err = ConnectSocket(config.Host, config.Port, config.TLS, config.TLSConfig)
+		conn, err = ConnectSocket(config)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		conn, err = NewIRCWebSocket(config.WebsocketURL, config.Origin, config.TLSConfig)
+		conn, err = NewIRCWebSocket(config)
 		if err != nil {
 			return nil, err
 		}
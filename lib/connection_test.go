@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// listenSilently accepts connections but never sends anything.
+func listenSilently(t *testing.T) *net.TCPAddr {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr)
+}
+
+func TestParseTCPAddr(t *testing.T) {
+	for input, expected := range map[string]string{
+		"203.0.113.5":        "203.0.113.5:0",
+		"203.0.113.5:4000":   "203.0.113.5:4000",
+		"2001:db8::5":        "[2001:db8::5]:0",
+		"[2001:db8::5]":      "[2001:db8::5]:0",
+		"[2001:db8::5]:4000": "[2001:db8::5]:4000",
+	} {
+		addr, err := ParseTCPAddr(input)
+		if err != nil || addr.String() != expected {
+			t.Errorf("expected %s for %s, got %v (%v)", expected, input, addr, err)
+		}
+	}
+	for _, input := range []string{"example.com", "203.0.113.5:99999", ""} {
+		if _, err := ParseTCPAddr(input); err == nil {
+			t.Errorf("expected %q to be invalid", input)
+		}
+	}
+}
+
+func TestConnectionTimeouts(t *testing.T) {
+	addr := listenSilently(t)
+	config := ConnectionConfig{
+		Host:             "127.0.0.1",
+		Port:             addr.Port,
+		TLS:              true,
+		HandshakeTimeout: 100 * time.Millisecond,
+	}
+	var timeout *TimeoutError
+	_, err := NewConnection(config)
+	if !errors.As(err, &timeout) || timeout.Operation != "TLS handshake" {
+		t.Errorf("expected TLS handshake timeout, got %v", err)
+	}
+
+	config.TLS = false
+	config.ReadTimeout = 100 * time.Millisecond
+	conn, err := NewConnection(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+	_, err = conn.GetLine()
+	if !errors.As(err, &timeout) || timeout.Operation != "read" || timeout.Duration != config.ReadTimeout {
+		t.Errorf("expected read timeout, got %v", err)
+	}
+
+	// other errors are unchanged
+	conn.Disconnect()
+	_, err = conn.GetLine()
+	if err == nil || errors.As(err, &timeout) {
+		t.Errorf("expected a non-timeout error after disconnecting, got %v", err)
+	}
+}
+
+func TestConnectionBindAddr(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	config := ConnectionConfig{
+		Host:     "127.0.0.1",
+		Port:     ln.Addr().(*net.TCPAddr).Port,
+		BindAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")},
+	}
+	conn, err := NewConnection(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+	accepted, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+	if source := accepted.RemoteAddr().(*net.TCPAddr); !source.IP.Equal(config.BindAddr.IP) {
+		t.Errorf("expected connection from %s, got %s", config.BindAddr.IP, source)
+	}
+
+	// an IPv4 address can't be reached over IPv6
+	config.BindAddr = nil
+	config.IPVersion = 6
+	if conn, err := NewConnection(config); err == nil {
+		conn.Disconnect()
+		t.Errorf("expected IPv6-only connection to an IPv4 address to fail")
+	}
+}
@@ -0,0 +1,328 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// lines being coalesced are written after this long, even if there
+	// are fewer than FaultConfig.Coalesce of them
+	CoalesceTimeout = 100 * time.Millisecond
+)
+
+var (
+	ErrFaultCut = errors.New("connection cut by fault injection")
+)
+
+// FaultConfig describes deliberate misbehavior by the proxy, for testing
+// how clients and servers deal with timing and partial reads.
+type FaultConfig struct {
+	// each line is delayed by a random duration in [DelayMin, DelayMax]
+	DelayMin time.Duration
+	DelayMax time.Duration
+	// if > 1, each line is written in this many pieces, with SplitPause between them
+	Split      int
+	SplitPause time.Duration
+	// if > 1, up to this many lines are written at once
+	Coalesce    int
+	DropPercent float64
+	// if nonzero, each connection is cut after this many lines, or this long
+	CutAfterLines int
+	CutAfterTime  time.Duration
+	// seed for the random number generators, so failures can be reproduced
+	Seed int64
+}
+
+// ParseDelayRange parses a duration like "200ms", or a range of durations
+// like "100ms-2s".
+func ParseDelayRange(input string) (minDelay, maxDelay time.Duration, err error) {
+	minStr, maxStr, isRange := strings.Cut(input, "-")
+	minDelay, err = time.ParseDuration(minStr)
+	if err != nil {
+		return
+	}
+	maxDelay = minDelay
+	if isRange {
+		maxDelay, err = time.ParseDuration(maxStr)
+		if err == nil && maxDelay < minDelay {
+			err = fmt.Errorf("invalid range %s", input)
+		}
+	}
+	return
+}
+
+// rawWriter is implemented by connections that can write arbitrary bytes,
+// rather than whole lines; this is necessary for splitting and coalescing.
+type rawWriter interface {
+	writeRaw([]byte) error
+}
+
+// FaultyWriter sends lines to a connection, misbehaving as configured. It is
+// safe for concurrent use; lines are written in the order they were passed
+// to SendLine or Inject.
+type FaultyWriter struct {
+	config    *FaultConfig
+	conn      IRCConnection
+	lineCount *atomic.Int64
+
+	// serializes sending, and guards rand and lastDue
+	sendMutex sync.Mutex
+	rand      *rand.Rand
+	// when the most recently delayed line is due to be written
+	lastDue time.Time
+
+	// guards the coalescing state, which is also accessed by a timer
+	mutex      sync.Mutex
+	pending    []byte
+	numPending int
+	timer      *time.Timer
+	// error from a write by the timer
+	err error
+
+	// with a delay, lines wait here to be written by a separate goroutine,
+	// so that the delays of consecutive lines overlap rather than add up
+	delayMutex sync.Mutex
+	delayed    []delayedLine
+	// signalled when a delayed line is written, or writing stops
+	delayWritten *sync.Cond
+	delayErr     error
+	wake         chan struct{}
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+type delayedLine struct {
+	line string
+	due  time.Time
+}
+
+// NewFaultyWriter returns a FaultyWriter that writes to conn. lineCount is
+// shared by all the writers for a connection, for FaultConfig.CutAfterLines;
+// seed should be different for each writer.
+// Close must be called when the writer is no longer needed; lines still
+// waiting for their delay are discarded.
+func NewFaultyWriter(config *FaultConfig, conn IRCConnection, seed int64, lineCount *atomic.Int64) *FaultyWriter {
+	w := &FaultyWriter{
+		config:    config,
+		conn:      conn,
+		rand:      rand.New(rand.NewSource(seed)),
+		lineCount: lineCount,
+		wake:      make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+	}
+	w.delayWritten = sync.NewCond(&w.delayMutex)
+	if config.DelayMax != 0 {
+		go w.writeDelayed()
+	}
+	return w
+}
+
+// SendLine sends a line, unless it is dropped. If the connection should now
+// be cut, it returns ErrFaultCut.
+func (w *FaultyWriter) SendLine(line string) (dropped bool, err error) {
+	w.sendMutex.Lock()
+	defer w.sendMutex.Unlock()
+	count := w.lineCount.Add(1)
+	if w.config.DropPercent != 0 && w.rand.Float64()*100 < w.config.DropPercent {
+		dropped = true
+	} else {
+		delay := w.config.DelayMin
+		if w.config.DelayMax > w.config.DelayMin {
+			delay += time.Duration(w.rand.Int63n(int64(w.config.DelayMax - w.config.DelayMin + 1)))
+		}
+		err = w.send(line, delay)
+	}
+	if err == nil && w.config.CutAfterLines != 0 && count >= int64(w.config.CutAfterLines) {
+		w.Flush()
+		err = ErrFaultCut
+	}
+	return
+}
+
+// Inject sends a line that didn't come from the other side of the proxy. It
+// is never dropped, delayed or counted, but it is written after any lines
+// already waiting to be written.
+func (w *FaultyWriter) Inject(line string) error {
+	w.sendMutex.Lock()
+	defer w.sendMutex.Unlock()
+	return w.send(line, 0)
+}
+
+// Flush waits for any delayed lines to be written, then writes any lines
+// that are being coalesced.
+func (w *FaultyWriter) Flush() error {
+	w.delayMutex.Lock()
+	for len(w.delayed) != 0 && w.delayErr == nil && !w.isClosed() {
+		w.delayWritten.Wait()
+	}
+	err := w.delayErr
+	w.delayMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.flush()
+}
+
+// Close stops writing delayed lines.
+func (w *FaultyWriter) Close() {
+	w.closeOnce.Do(func() {
+		close(w.closed)
+		w.delayMutex.Lock()
+		w.delayWritten.Broadcast()
+		w.delayMutex.Unlock()
+	})
+}
+
+func (w *FaultyWriter) isClosed() bool {
+	select {
+	case <-w.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// send must be called with sendMutex held.
+func (w *FaultyWriter) send(line string, delay time.Duration) error {
+	if w.config.DelayMax == 0 {
+		return w.write(line)
+	}
+	due := time.Now().Add(delay)
+	if due.Before(w.lastDue) {
+		// a shorter delay mustn't let a line overtake the previous one
+		due = w.lastDue
+	}
+	w.lastDue = due
+
+	w.delayMutex.Lock()
+	if w.delayErr != nil {
+		w.delayMutex.Unlock()
+		return w.delayErr
+	}
+	w.delayed = append(w.delayed, delayedLine{line: line, due: due})
+	w.delayMutex.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// writeDelayed writes each delayed line when it is due, until Close is
+// called or a write fails.
+func (w *FaultyWriter) writeDelayed() {
+	for {
+		w.delayMutex.Lock()
+		if len(w.delayed) == 0 {
+			w.delayMutex.Unlock()
+			select {
+			case <-w.wake:
+				continue
+			case <-w.closed:
+				return
+			}
+		}
+		next := w.delayed[0]
+		w.delayMutex.Unlock()
+
+		if wait := time.Until(next.due); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-w.closed:
+				timer.Stop()
+				return
+			}
+		}
+		err := w.write(next.line)
+		w.delayMutex.Lock()
+		w.delayed = w.delayed[1:]
+		if err != nil {
+			w.delayErr = err
+			w.delayed = nil
+		}
+		w.delayWritten.Broadcast()
+		w.delayMutex.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (w *FaultyWriter) write(line string) error {
+	raw, ok := w.conn.(rawWriter)
+	if !ok {
+		// splitting and coalescing aren't possible (e.g., for WebSocket)
+		return w.conn.SendLine(line)
+	}
+	data := []byte(line + "\r\n")
+	if w.config.Coalesce < 2 {
+		return w.split(raw, data)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	w.pending = append(w.pending, data...)
+	w.numPending++
+	if w.numPending >= w.config.Coalesce {
+		return w.flush()
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(CoalesceTimeout, func() {
+			w.mutex.Lock()
+			defer w.mutex.Unlock()
+			w.err = w.flush()
+		})
+	}
+	return nil
+}
+
+// flush must be called with the mutex held.
+func (w *FaultyWriter) flush() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if len(w.pending) == 0 {
+		return nil
+	}
+	data := w.pending
+	w.pending, w.numPending = nil, 0
+	return w.split(w.conn.(rawWriter), data)
+}
+
+func (w *FaultyWriter) split(raw rawWriter, data []byte) error {
+	pieces := w.config.Split
+	if pieces > len(data) {
+		pieces = len(data)
+	}
+	if pieces < 2 {
+		return raw.writeRaw(data)
+	}
+	size := len(data) / pieces
+	for i := 0; i < pieces; i++ {
+		end := (i + 1) * size
+		if i == pieces-1 {
+			end = len(data)
+		}
+		if i != 0 {
+			time.Sleep(w.config.SplitPause)
+		}
+		if err := raw.writeRaw(data[i*size : end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
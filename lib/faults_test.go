@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rawRecorder is an IRCConnection that records the individual writes to it.
+type rawRecorder struct {
+	sync.Mutex
+	writes []string
+}
+
+func (r *rawRecorder) SendLine(line string) error {
+	return r.writeRaw([]byte(line + "\r\n"))
+}
+
+func (r *rawRecorder) writeRaw(data []byte) error {
+	r.Lock()
+	defer r.Unlock()
+	r.writes = append(r.writes, string(data))
+	return nil
+}
+
+func (r *rawRecorder) getWrites() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string(nil), r.writes...)
+}
+
+func (r *rawRecorder) GetLine() (string, error) { return "", nil }
+func (r *rawRecorder) Disconnect()              {}
+func (r *rawRecorder) RemoteAddr() net.Addr     { return nil }
+
+func TestFaultySplit(t *testing.T) {
+	conn := new(rawRecorder)
+	w := NewFaultyWriter(&FaultConfig{Split: 3}, conn, 0, new(atomic.Int64))
+	if _, err := w.SendLine("PING abc"); err != nil {
+		t.Fatal(err)
+	}
+	assertLines(t, conn.getWrites(), "PIN", "G a", "bc\r\n")
+}
+
+func TestFaultyCoalesce(t *testing.T) {
+	conn := new(rawRecorder)
+	w := NewFaultyWriter(&FaultConfig{Coalesce: 2}, conn, 0, new(atomic.Int64))
+	w.SendLine("PING a")
+	w.SendLine("PING b")
+	w.SendLine("PING c")
+	assertLines(t, conn.getWrites(), "PING a\r\nPING b\r\n")
+	time.Sleep(2 * CoalesceTimeout)
+	assertLines(t, conn.getWrites(), "PING a\r\nPING b\r\n", "PING c\r\n")
+}
+
+func TestFaultyDelay(t *testing.T) {
+	conn := new(rawRecorder)
+	delay := 100 * time.Millisecond
+	w := NewFaultyWriter(&FaultConfig{DelayMin: delay, DelayMax: delay}, conn, 0, new(atomic.Int64))
+	defer w.Close()
+	start := time.Now()
+	for _, line := range []string{"PING a", "PING b", "PING c"} {
+		if _, err := w.SendLine(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Errorf("SendLine waited out the delay: %v", elapsed)
+	}
+	w.Flush()
+	// the delays overlap, rather than adding up
+	if elapsed := time.Since(start); elapsed < delay || elapsed >= 2*delay {
+		t.Errorf("expected lines to arrive after one delay, took %v", elapsed)
+	}
+	assertLines(t, conn.getWrites(), "PING a\r\n", "PING b\r\n", "PING c\r\n")
+}
+
+func TestFaultyDelayOrder(t *testing.T) {
+	conn := new(rawRecorder)
+	w := NewFaultyWriter(&FaultConfig{DelayMax: 50 * time.Millisecond, Coalesce: 2}, conn, 1, new(atomic.Int64))
+	defer w.Close()
+	var expected []string
+	for i := 0; i < 10; i++ {
+		line := fmt.Sprintf("PING %d", i)
+		if i%3 == 0 {
+			w.Inject(line)
+		} else {
+			w.SendLine(line)
+		}
+		expected = append(expected, line)
+	}
+	w.Flush()
+	var lines []string
+	for _, write := range conn.getWrites() {
+		lines = append(lines, strings.Split(strings.TrimSuffix(write, "\r\n"), "\r\n")...)
+	}
+	assertLines(t, lines, expected...)
+}
+
+func TestFaultyDropAndCut(t *testing.T) {
+	sendLines := func(seed int64) (dropped []bool, err error) {
+		conn := new(rawRecorder)
+		w := NewFaultyWriter(&FaultConfig{DropPercent: 50, CutAfterLines: 20, Seed: seed}, conn, seed, new(atomic.Int64))
+		for i := 0; i < 20; i++ {
+			var d bool
+			d, err = w.SendLine("PING x")
+			dropped = append(dropped, d)
+		}
+		return
+	}
+	first, err := sendLines(42)
+	if err != ErrFaultCut {
+		t.Errorf("expected connection to be cut, got %v", err)
+	}
+	second, _ := sendLines(42)
+	numDropped := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Errorf("drops were not reproducible with the same seed: %v, %v", first, second)
+			break
+		}
+		if first[i] {
+			numDropped++
+		}
+	}
+	if numDropped == 0 || numDropped == len(first) {
+		t.Errorf("expected some lines to be dropped, got %v", first)
+	}
+}
+
+func TestParseDelayRange(t *testing.T) {
+	minDelay, maxDelay, err := ParseDelayRange("100ms-2s")
+	if err != nil || minDelay != 100*time.Millisecond || maxDelay != 2*time.Second {
+		t.Errorf("bad range: %v %v %v", minDelay, maxDelay, err)
+	}
+	minDelay, maxDelay, err = ParseDelayRange("1s")
+	if err != nil || minDelay != time.Second || maxDelay != time.Second {
+		t.Errorf("bad range: %v %v %v", minDelay, maxDelay, err)
+	}
+	if _, _, err := ParseDelayRange("2s-1s"); err == nil {
+		t.Errorf("accepted backwards range")
+	}
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidInjection = errors.New("expected a direction prefix: >, < or <>, optionally preceded by a connection ID")
+)
+
+// Injection is a line typed at the console in proxy mode, to be inserted
+// into the traffic between the client and the server. The syntax is a
+// direction prefix, then a space, then the line:
+//
+//	> MODE #chan +o dog            (to the server)
+//	< :irc.test NOTICE * :hello    (to the client)
+//	<> PING :both                  (to both)
+//
+// The prefix may be preceded by a connection ID (e.g. `2>`); otherwise,
+// the line is injected into every active connection.
+type Injection struct {
+	// 0 for all connections
+	ConnectionID uint64
+	ToServer     bool
+	ToClient     bool
+	Line         string
+}
+
+func ParseInjection(input string) (result Injection, err error) {
+	prefix, line, found := strings.Cut(input, " ")
+	if !found || line == "" {
+		return result, ErrInvalidInjection
+	}
+	direction := strings.TrimLeft(prefix, "0123456789")
+	if id := prefix[:len(prefix)-len(direction)]; id != "" {
+		result.ConnectionID, err = strconv.ParseUint(id, 10, 64)
+		if err != nil || result.ConnectionID == 0 {
+			return result, ErrInvalidInjection
+		}
+	}
+	switch direction {
+	case ">":
+		result.ToServer = true
+	case "<":
+		result.ToClient = true
+	case "<>":
+		result.ToServer, result.ToClient = true, true
+	default:
+		return result, ErrInvalidInjection
+	}
+	result.Line = line
+	return result, nil
+}
@@ -0,0 +1,28 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestParseInjection(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected Injection
+	}{
+		{"> MODE #chan +o dog", Injection{ToServer: true, Line: "MODE #chan +o dog"}},
+		{"< :irc.test NOTICE * :hello world", Injection{ToClient: true, Line: ":irc.test NOTICE * :hello world"}},
+		{"12<> PING x", Injection{ConnectionID: 12, ToServer: true, ToClient: true, Line: "PING x"}},
+	}
+	for _, testCase := range cases {
+		actual, err := ParseInjection(testCase.input)
+		if err != nil || actual != testCase.expected {
+			t.Errorf("%s: expected %#v, got %#v (%v)", testCase.input, testCase.expected, actual, err)
+		}
+	}
+
+	for _, input := range []string{"PRIVMSG #chan :hi", ">PING x", "> ", "0> PING x", "2>< PING x"} {
+		if _, err := ParseInjection(input); err != ErrInvalidInjection {
+			t.Errorf("%s: expected ErrInvalidInjection, got %v", input, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	// directions for JSON output; "in" and "out" are from the perspective
+	// of the client, so in proxy mode, "out" is from the client to the server
+	DirectionIn    = "in"
+	DirectionOut   = "out"
+	DirectionEvent = "event"
+	// lines injected from the console in proxy mode
+	DirectionInjectedIn  = "injected-in"
+	DirectionInjectedOut = "injected-out"
+	// lines sent in place of received lines, due to proxy rewrite rules
+	DirectionRewrittenIn  = "rewritten-in"
+	DirectionRewrittenOut = "rewritten-out"
+
+	// same as the IRCv3 server-time format
+	JSONTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// JSONLine is the representation of a line of traffic, or an ircdog event,
+// in JSON output mode.
+type JSONLine struct {
+	Time       string `json:"time"`
+	Direction  string `json:"direction"`
+	Connection uint64 `json:"conn,omitempty"`
+	// for events, a human-readable description
+	Message string `json:"message,omitempty"`
+	Raw     string `json:"raw,omitempty"`
+	// if the raw line is not valid UTF-8, it is also included as base64
+	RawBase64  string            `json:"raw_base64,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Command    string            `json:"command,omitempty"`
+	Params     []string          `json:"params,omitempty"`
+	ParseError string            `json:"parse_error,omitempty"`
+}
+
+// MakeJSONLine serializes a line of traffic as JSON (without a trailing newline).
+func MakeJSONLine(line string, direction string, connectionID uint64, when time.Time) []byte {
+	result := JSONLine{
+		Time:       when.UTC().Format(JSONTimeFormat),
+		Direction:  direction,
+		Connection: connectionID,
+		Raw:        line,
+	}
+	if !utf8.ValidString(line) {
+		result.RawBase64 = base64.StdEncoding.EncodeToString([]byte(line))
+	}
+	msg, err := ircmsg.ParseLine(line)
+	if err == nil {
+		if tags := msg.AllTags(); len(tags) != 0 {
+			result.Tags = tags
+		}
+		result.Source = msg.Source
+		result.Command = msg.Command
+		result.Params = msg.Params
+	} else {
+		result.ParseError = err.Error()
+	}
+	return marshalJSONLine(&result)
+}
+
+// MakeJSONEvent serializes an ircdog event (e.g., an error message) as JSON.
+func MakeJSONEvent(message string, connectionID uint64, when time.Time) []byte {
+	return marshalJSONLine(&JSONLine{
+		Time:       when.UTC().Format(JSONTimeFormat),
+		Direction:  DirectionEvent,
+		Connection: connectionID,
+		Message:    message,
+	})
+}
+
+func marshalJSONLine(line *JSONLine) []byte {
+	// this can't fail: all fields are strings, string slices, or string maps
+	result, _ := json.Marshal(line)
+	return result
+}
@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMakeJSONLine(t *testing.T) {
+	when := time.Date(2024, 9, 15, 12, 0, 0, 0, time.UTC)
+	var result JSONLine
+	if err := json.Unmarshal(MakeJSONLine(`@msgid=abc;+draft/reply=x\sy :dog!u@h PRIVMSG #chan :hi there`, DirectionIn, 3, when), &result); err != nil {
+		t.Fatal(err)
+	}
+	expected := JSONLine{
+		Time:       "2024-09-15T12:00:00.000Z",
+		Direction:  DirectionIn,
+		Connection: 3,
+		Raw:        `@msgid=abc;+draft/reply=x\sy :dog!u@h PRIVMSG #chan :hi there`,
+		Tags:       map[string]string{"msgid": "abc", "+draft/reply": "x y"},
+		Source:     "dog!u@h",
+		Command:    "PRIVMSG",
+		Params:     []string{"#chan", "hi there"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, result)
+	}
+
+	result = JSONLine{}
+	if err := json.Unmarshal(MakeJSONLine("PRIVMSG #chan \xff", DirectionOut, 0, when), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.RawBase64 != "UFJJVk1TRyAjY2hhbiD/" {
+		t.Errorf("expected base64 for invalid UTF-8, got %#v", result)
+	}
+
+	result = JSONLine{}
+	if err := json.Unmarshal(MakeJSONLine("@tag= ", DirectionIn, 0, when), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.ParseError == "" || result.Command != "" {
+		t.Errorf("expected a parse error, got %#v", result)
+	}
+}
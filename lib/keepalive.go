@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Keepalive tracks the PINGs that ircdog sends to check that the connection
+// is alive, and measures the lag from the matching PONGs. It is safe for
+// concurrent use.
+type Keepalive struct {
+	mutex sync.Mutex
+	// distinguishes our tokens from any PINGs sent by the user or a script
+	prefix  string
+	counter uint64
+	// unanswered PINGs, by token
+	pending map[string]pendingPing
+}
+
+type pendingPing struct {
+	counter uint64
+	// zero until Sent is called
+	sent time.Time
+}
+
+func NewKeepalive() *Keepalive {
+	return &Keepalive{
+		prefix:  fmt.Sprintf("ircdog-%08x-", rand.Uint32()),
+		pending: make(map[string]pendingPing),
+	}
+}
+
+// Ping returns a PING line with a new token; Sent must be called once it
+// has been written.
+func (k *Keepalive) Ping() (line, token string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.counter++
+	token = fmt.Sprintf("%s%d", k.prefix, k.counter)
+	k.pending[token] = pendingPing{counter: k.counter}
+	return makeLine("PING", token), token
+}
+
+// Sent records when the PING with a token was written, so that the lag can
+// be measured from its PONG. This is separate from Ping, since the line may
+// wait in a queue before being written.
+func (k *Keepalive) Sent(token string, now time.Time) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	// the PONG may already have been handled
+	if ping, ok := k.pending[token]; ok {
+		ping.sent = now
+		k.pending[token] = ping
+	}
+}
+
+// HandlePong processes a PONG from the server. If it answers one of our
+// PINGs, it returns the round-trip time and ok=true.
+func (k *Keepalive) HandlePong(msg ircmsg.Message, now time.Time) (lag time.Duration, ok bool) {
+	if msg.Command != "PONG" || len(msg.Params) == 0 {
+		return
+	}
+	token := msg.Params[len(msg.Params)-1]
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	ping, ok := k.pending[token]
+	if !ok {
+		return
+	}
+	delete(k.pending, token)
+	// PONGs arrive in order, so any earlier PINGs won't be answered
+	for other, otherPing := range k.pending {
+		if otherPing.counter < ping.counter {
+			delete(k.pending, other)
+		}
+	}
+	if ping.sent.IsZero() {
+		// the PONG arrived before Sent was called, so the lag is negligible
+		return 0, true
+	}
+	return now.Sub(ping.sent), true
+}
+
+// Waiting returns whether the PING with a token is still unanswered.
+func (k *Keepalive) Waiting(token string) bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	_, ok := k.pending[token]
+	return ok
+}
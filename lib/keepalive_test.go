@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func TestKeepalive(t *testing.T) {
+	k := NewKeepalive()
+	start := time.Now()
+	line, token := k.Ping()
+	k.Sent(token, start)
+	if line != "PING "+token || !strings.HasPrefix(token, "ircdog-") {
+		t.Errorf("bad PING line %q", line)
+	}
+	line2, token2 := k.Ping()
+	k.Sent(token2, start.Add(time.Second))
+	if token2 == token {
+		t.Errorf("expected unique tokens, got %q twice", token)
+	}
+	if !k.Waiting(token) || !k.Waiting(token2) {
+		t.Errorf("expected both PINGs to be waiting")
+	}
+
+	// PONGs that aren't ours are ignored
+	pong, _ := ircmsg.ParseLine(":irc.example.com PONG irc.example.com :user-token")
+	if _, ok := k.HandlePong(pong, start); ok {
+		t.Errorf("expected PONG for another token to be ignored")
+	}
+
+	pong, _ = ircmsg.ParseLine(":irc.example.com PONG irc.example.com :" + strings.TrimPrefix(line2, "PING "))
+	lag, ok := k.HandlePong(pong, start.Add(1250*time.Millisecond))
+	if !ok || lag != 250*time.Millisecond {
+		t.Errorf("expected lag of 250ms, got %v (%t)", lag, ok)
+	}
+	// the earlier PING can no longer be answered
+	if k.Waiting(token) || k.Waiting(token2) {
+		t.Errorf("expected no PINGs to be waiting")
+	}
+}
+
+func TestKeepalivePongBeforeSent(t *testing.T) {
+	k := NewKeepalive()
+	line, token := k.Ping()
+	// the PONG can be read before the sender records the time
+	pong, _ := ircmsg.ParseLine(":irc.example.com PONG irc.example.com :" + strings.TrimPrefix(line, "PING "))
+	if lag, ok := k.HandlePong(pong, time.Now()); !ok || lag != 0 {
+		t.Errorf("expected PONG to be matched with no lag, got %v (%t)", lag, ok)
+	}
+	k.Sent(token, time.Now())
+	if k.Waiting(token) {
+		t.Errorf("expected the answered PING not to be waiting")
+	}
+}
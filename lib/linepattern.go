@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// LinePattern matches lines of proxied traffic by direction, command and
+// regex. Its syntax is a direction (> for client to server, < for server to
+// client, or <> for both), then a command or numeric (* for any), then
+// optionally a /regular expression/ matched against the raw line.
+type LinePattern struct {
+	ToServer bool
+	ToClient bool
+	// empty for any command
+	Command string
+	// nil if the pattern doesn't have a regex
+	Regex *regexp.Regexp
+}
+
+// ParseLinePattern parses a pattern like `> PRIVMSG /^PRIVMSG #chan :/`.
+func ParseLinePattern(input string) (pattern LinePattern, err error) {
+	direction, rest, _ := strings.Cut(input, " ")
+	switch direction {
+	case ">":
+		pattern.ToServer = true
+	case "<":
+		pattern.ToClient = true
+	case "<>":
+		pattern.ToServer, pattern.ToClient = true, true
+	default:
+		return pattern, fmt.Errorf("expected a direction: >, < or <>")
+	}
+	rest = strings.TrimSpace(rest)
+	if !strings.HasPrefix(rest, "/") {
+		pattern.Command, rest, _ = strings.Cut(rest, " ")
+		rest = strings.TrimSpace(rest)
+		if pattern.Command == "" {
+			return pattern, fmt.Errorf("expected a command, or * for any")
+		} else if pattern.Command == "*" {
+			pattern.Command = ""
+		}
+	}
+	if rest != "" {
+		if len(rest) < 2 || rest[0] != '/' || rest[len(rest)-1] != '/' {
+			return pattern, fmt.Errorf("expected a /regular expression/")
+		}
+		pattern.Regex, err = regexp.Compile(rest[1 : len(rest)-1])
+	}
+	return
+}
+
+// Match returns nil if the line doesn't match, otherwise the submatches of
+// the pattern's regex (or just the line, if it has no regex).
+func (pattern *LinePattern) Match(line string, toServer bool) (submatches []string) {
+	if (toServer && !pattern.ToServer) || (!toServer && !pattern.ToClient) {
+		return nil
+	}
+	if pattern.Command != "" {
+		msg, err := ircmsg.ParseLine(line)
+		if err != nil || !strings.EqualFold(msg.Command, pattern.Command) {
+			return nil
+		}
+	}
+	if pattern.Regex == nil {
+		return []string{line}
+	}
+	return pattern.Regex.FindStringSubmatch(line)
+}
+
+// Breakpoint is a pattern for lines that the proxy should pause, so the user
+// can decide what to do with them.
+type Breakpoint struct {
+	LinePattern
+	// the pattern as written, for display
+	Text string
+}
+
+// ReadBreakpoints reads a breakpoints file, which contains a LinePattern
+// on each line; lines beginning with # are comments.
+func ReadBreakpoints(filename string) (breakpoints []Breakpoint, err error) {
+	infile, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer infile.Close()
+	scanner := bufio.NewScanner(infile)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, err := ParseLinePattern(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		breakpoints = append(breakpoints, Breakpoint{LinePattern: pattern, Text: line})
+	}
+	return breakpoints, scanner.Err()
+}
@@ -0,0 +1,33 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadBreakpoints(t *testing.T) {
+	breakpointsFile := filepath.Join(t.TempDir(), "breakpoints")
+	err := os.WriteFile(breakpointsFile, []byte("# comment\n> PRIVMSG\n\n<> /^:\\S+ 00[1-5] /\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	breakpoints, err := ReadBreakpoints(breakpointsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(breakpoints) != 2 || breakpoints[0].Text != "> PRIVMSG" {
+		t.Fatalf("bad breakpoints: %#v", breakpoints)
+	}
+	if breakpoints[0].Match("privmsg #chan hi", true) == nil || breakpoints[0].Match("PRIVMSG #chan hi", false) != nil {
+		t.Errorf("bad matching for %s", breakpoints[0].Text)
+	}
+	if breakpoints[1].Match(":irc.test 005 dog :hi", false) == nil || breakpoints[1].Match(":irc.test 422 dog :hi", true) != nil {
+		t.Errorf("bad matching for %s", breakpoints[1].Text)
+	}
+
+	os.WriteFile(breakpointsFile, []byte("PRIVMSG\n"), 0600)
+	if _, err := ReadBreakpoints(breakpointsFile); err == nil {
+		t.Errorf("accepted breakpoint without a direction")
+	}
+}
@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ListenerConfig describes how ircdog accepts clients, in --listen, serve
+// or replay mode.
+type ListenerConfig struct {
+	// if non-nil, clients must connect with TLS
+	TLSConfig *tls.Config
+	// accept WebSocket connections over HTTP, instead of raw IRC
+	WebSocket bool
+	// if nonempty, WebSocket clients must send one of these Origin headers
+	AllowedOrigins []string
+	// log the details of WebSocket handshakes
+	Verbose bool
+	// if set, receives messages about rejected connections and handshakes
+	Log func(string)
+	// if nonzero, the permissions of a Unix socket listener
+	UnixSocketMode os.FileMode
+	// clients must send a PROXY protocol header, which determines their address
+	ProxyProtocol bool
+}
+
+func (config *ListenerConfig) log(message string) {
+	if config.Log != nil {
+		config.Log(message)
+	}
+}
+
+// IRCListener accepts IRC connections from clients.
+type IRCListener interface {
+	Accept() (IRCConnection, error)
+	Close() error
+	Addr() net.Addr
+}
+
+// Listen opens a listener on an address like ":6667", or a Unix socket
+// given as "unix:/path/to/socket".
+func Listen(address string, config ListenerConfig) (result IRCListener, err error) {
+	var ln net.Listener
+	if strings.HasPrefix(address, "unix:") {
+		ln, err = listenUnix(strings.TrimPrefix(address, "unix:"), config.UnixSocketMode)
+	} else {
+		ln, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return
+	}
+	if config.ProxyProtocol {
+		// the PROXY header precedes the TLS handshake
+		ln = &proxyHeaderListener{Listener: ln, log: config.log}
+	}
+	if config.TLSConfig != nil {
+		ln = tls.NewListener(ln, config.TLSConfig)
+	}
+	if config.WebSocket {
+		result, err = newWebSocketListener(ln, config)
+		if err != nil {
+			ln.Close()
+		}
+		return
+	}
+	return &socketListener{ln}, nil
+}
+
+func listenUnix(path string, mode os.FileMode) (ln net.Listener, err error) {
+	// remove a socket left behind by a previous run, but nothing else
+	if info, statErr := os.Lstat(path); statErr == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, dialErr := net.Dial("unix", path); dialErr == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return
+		}
+	}
+	ln, err = net.Listen("unix", path)
+	if err != nil || mode == 0 {
+		return
+	}
+	if err = os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return
+}
+
+type socketListener struct {
+	net.Listener
+}
+
+func (s *socketListener) Accept() (IRCConnection, error) {
+	conn, err := s.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return MakeSocket(conn), nil
+}
+
+// OriginAllowed returns whether a WebSocket Origin header is acceptable.
+func OriginAllowed(origin string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	origin = strings.TrimSuffix(origin, "/")
+	for _, candidate := range allowed {
+		if strings.EqualFold(origin, strings.TrimSuffix(candidate, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateSelfSignedCertificate generates an ephemeral certificate for
+// listening with TLS, when the user doesn't supply one.
+func GenerateSelfSignedCertificate() (cert tls.Certificate, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: "ircdog"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(30 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of a DER-encoded
+// certificate, as lowercase hex; this is the usual format for IRC CertFP.
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// ClientCertificateFingerprint completes the TLS handshake, if conn is
+// using TLS, and returns the fingerprint of the client's certificate, if any.
+func ClientCertificateFingerprint(conn IRCConnection) (fingerprint string, err error) {
+	var tlsConn *tls.Conn
+	switch conn := conn.(type) {
+	case *Socket:
+		tlsConn, _ = conn.connection.(*tls.Conn)
+	case interface{ underlyingConn() net.Conn }:
+		tlsConn, _ = conn.underlyingConn().(*tls.Conn)
+	}
+	if tlsConn == nil {
+		return
+	}
+	if err = tlsConn.Handshake(); err != nil {
+		return
+	}
+	if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) != 0 {
+		fingerprint = CertificateFingerprint(certs[0].Raw)
+	}
+	return
+}
@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenTLS(t *testing.T) {
+	serverCert, err := GenerateSelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := GenerateSelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := Listen("127.0.0.1:0", ListenerConfig{
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientAuth:   tls.RequestClientCert,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       []tls.Certificate{clientCert},
+		})
+		if err == nil {
+			conn.Write([]byte("PING x\r\n"))
+			conn.Close()
+		}
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+	fingerprint, err := ClientCertificateFingerprint(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := CertificateFingerprint(clientCert.Certificate[0]); fingerprint != expected || len(fingerprint) != 64 {
+		t.Errorf("expected fingerprint %s, got %s", expected, fingerprint)
+	}
+	line, err := conn.GetLine()
+	if err != nil || line != "PING x" {
+		t.Errorf("bad line %q: %v", line, err)
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://example.com", "http://localhost:8080/"}
+	for origin, expected := range map[string]bool{
+		"https://example.com":    true,
+		"https://EXAMPLE.com/":   true,
+		"http://localhost:8080":  true,
+		"http://example.com":     false,
+		"https://example.com.ru": false,
+		"":                       false,
+	} {
+		if OriginAllowed(origin, allowed) != expected {
+			t.Errorf("expected OriginAllowed(%q) to be %t", origin, expected)
+		}
+	}
+	if !OriginAllowed("https://example.com", nil) {
+		t.Errorf("expected any origin to be allowed by an empty list")
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ircdog.sock")
+	// a stale socket file, as left behind by a process that was killed
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := Listen("unix:"+path, ListenerConfig{UnixSocketMode: 0600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("expected mode 0600, got %o", mode)
+	}
+	if _, err := Listen("unix:"+path, ListenerConfig{}); err == nil {
+		t.Errorf("expected a socket in use not to be replaced")
+	}
+
+	go func() {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Write([]byte("PING x\r\n"))
+			conn.Close()
+		}
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// skip the connection that checked whether the socket was in use
+		line, err := conn.GetLine()
+		conn.Disconnect()
+		if err == nil {
+			if line != "PING x" {
+				t.Errorf("bad line %q", line)
+			}
+			break
+		}
+	}
+}
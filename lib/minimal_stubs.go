@@ -3,10 +3,14 @@
 package lib
 
 import (
-	"crypto/tls"
 	"errors"
+	"net"
 )
 
-func NewIRCWebSocket(wsUrl, origin string, tlsConfig *tls.Config) (IRCConnection, error) {
+func NewIRCWebSocket(config ConnectionConfig) (IRCConnection, error) {
+	return nil, errors.New("websocket support disabled at compile time")
+}
+
+func newWebSocketListener(ln net.Listener, config ListenerConfig) (IRCListener, error) {
 	return nil, errors.New("websocket support disabled at compile time")
 }
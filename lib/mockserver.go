@@ -0,0 +1,252 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Rules files for the mock server contain rules, each of which is a
+// /regular expression/ on its own line, followed by indented actions:
+//
+//	/^PRIVMSG (\S+) :!echo (.*)/
+//		:${nick}!u@ircdog PRIVMSG ${1} :${2}
+//		!sleep 2s
+//		!disconnect Echo service is closing
+//
+// The regular expression is matched against each raw line from the client;
+// the first matching rule's actions are run in place of the server's built-in
+// handling. Actions are lines to send, in which ${nick}, ${server}, ${0}
+// (the entire line), numbered and named groups are substituted; `!sleep
+// <duration>`; and `!disconnect [<message>]`, which sends ERROR and closes
+// the connection.
+
+const (
+	DefaultMockServerName = "ircdog.mock"
+)
+
+// MockServerRule is a rule from a rules file.
+type MockServerRule struct {
+	Regex   *regexp.Regexp
+	Actions []MockServerAction
+}
+
+// MockServerAction is a step in the server's response to a line.
+type MockServerAction struct {
+	// a line to send; for rules, this is a template
+	Line       string
+	Delay      time.Duration
+	Disconnect bool
+}
+
+// ReadMockServerRules reads and parses a rules file.
+func ReadMockServerRules(filename string) (rules []MockServerRule, err error) {
+	infile, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer infile.Close()
+	scanner := bufio.NewScanner(infile)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			// new rule
+			if len(trimmed) < 2 || trimmed[0] != '/' || trimmed[len(trimmed)-1] != '/' {
+				return nil, fmt.Errorf("line %d: rules must begin with a /regular expression/", lineNum)
+			}
+			regex, err := regexp.Compile(trimmed[1 : len(trimmed)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			rules = append(rules, MockServerRule{Regex: regex})
+			continue
+		}
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("line %d: action outside of a rule", lineNum)
+		}
+		actions, err := parseMockServerAction(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		rule := &rules[len(rules)-1]
+		rule.Actions = append(rule.Actions, actions...)
+	}
+	return rules, scanner.Err()
+}
+
+func parseMockServerAction(action string) (result []MockServerAction, err error) {
+	if !strings.HasPrefix(action, "!") {
+		return []MockServerAction{{Line: action}}, nil
+	}
+	directive, rest, _ := strings.Cut(action[1:], " ")
+	rest = strings.TrimSpace(rest)
+	switch strings.ToLower(directive) {
+	case "sleep":
+		delay, err := parseScriptDuration(rest)
+		if err != nil {
+			return nil, err
+		}
+		return []MockServerAction{{Delay: delay}}, nil
+	case "disconnect":
+		if rest != "" {
+			result = append(result, MockServerAction{Line: makeLine("ERROR", rest)})
+		}
+		return append(result, MockServerAction{Disconnect: true}), nil
+	default:
+		return nil, fmt.Errorf("unknown directive `%s`", directive)
+	}
+}
+
+// MockServerSession is the server side of a single client connection to the
+// mock server. Like Registrar, it does no I/O of its own.
+type MockServerSession struct {
+	serverName string
+	rules      []MockServerRule
+
+	nick           string
+	user           string
+	capNegotiating bool
+	registered     bool
+}
+
+func NewMockServerSession(serverName string, rules []MockServerRule) *MockServerSession {
+	return &MockServerSession{
+		serverName: serverName,
+		rules:      rules,
+	}
+}
+
+// HandleLine processes a line from the client, returning the actions to take.
+func (s *MockServerSession) HandleLine(line string) (actions []MockServerAction) {
+	for _, rule := range s.rules {
+		if submatches := rule.Regex.FindStringSubmatch(line); submatches != nil {
+			return s.expandRule(&rule, submatches)
+		}
+	}
+
+	msg, err := ircmsg.ParseLine(line)
+	if err != nil {
+		return nil
+	}
+	switch strings.ToUpper(msg.Command) {
+	case "CAP":
+		if len(msg.Params) == 0 {
+			return nil
+		}
+		switch strings.ToUpper(msg.Params[0]) {
+		case "LS":
+			if !s.registered {
+				s.capNegotiating = true
+			}
+			actions = s.reply("CAP", s.target(), "LS", "")
+		case "REQ":
+			if len(msg.Params) > 1 {
+				actions = s.reply("CAP", s.target(), "NAK", msg.Params[1])
+			}
+		case "END":
+			s.capNegotiating = false
+			actions = s.tryRegister()
+		}
+	case "NICK":
+		if len(msg.Params) == 0 {
+			return s.reply("431", s.target(), "No nickname given")
+		}
+		if s.registered {
+			actions = []MockServerAction{{Line: makeLineWithSource(s.nick+"!"+s.user+"@ircdog", "NICK", msg.Params[0])}}
+			s.nick = msg.Params[0]
+			return actions
+		}
+		s.nick = msg.Params[0]
+		actions = s.tryRegister()
+	case "USER":
+		if len(msg.Params) < 4 {
+			return s.reply("461", s.target(), "USER", "Not enough parameters")
+		}
+		if !s.registered {
+			s.user = msg.Params[0]
+			actions = s.tryRegister()
+		}
+	case "PING":
+		if len(msg.Params) != 0 {
+			actions = s.reply("PONG", s.serverName, msg.Params[0])
+		}
+	case "QUIT":
+		actions = []MockServerAction{{Line: makeLine("ERROR", "Quit")}, {Disconnect: true}}
+	}
+	return
+}
+
+func (s *MockServerSession) tryRegister() (actions []MockServerAction) {
+	if s.registered || s.capNegotiating || s.nick == "" || s.user == "" {
+		return
+	}
+	s.registered = true
+	actions = append(actions, s.reply("001", s.nick, fmt.Sprintf("Welcome to the ircdog mock server, %s", s.nick))...)
+	actions = append(actions, s.reply("002", s.nick, fmt.Sprintf("Your host is %s, running version ircdog-%s", s.serverName, SemVer))...)
+	actions = append(actions, s.reply("003", s.nick, "This server was created just now")...)
+	actions = append(actions, s.reply("004", s.nick, s.serverName, "ircdog-"+SemVer, "i", "o")...)
+	actions = append(actions, s.reply("005", s.nick, "CASEMAPPING=ascii", "CHANTYPES=#", "NETWORK=ircdog", "are supported by this server")...)
+	actions = append(actions, s.reply("422", s.nick, "MOTD File is missing")...)
+	return
+}
+
+func (s *MockServerSession) target() string {
+	if s.nick == "" {
+		return "*"
+	}
+	return s.nick
+}
+
+func (s *MockServerSession) reply(command string, params ...string) []MockServerAction {
+	return []MockServerAction{{Line: makeLineWithSource(s.serverName, command, params...)}}
+}
+
+func (s *MockServerSession) expandRule(rule *MockServerRule, submatches []string) (actions []MockServerAction) {
+	vars := regexVariables(rule.Regex, submatches)
+	vars["nick"] = s.target()
+	vars["server"] = s.serverName
+	actions = make([]MockServerAction, len(rule.Actions))
+	copy(actions, rule.Actions)
+	for i := range actions {
+		if actions[i].Line != "" {
+			actions[i].Line = expandTemplate(actions[i].Line, vars)
+		}
+	}
+	return
+}
+
+// regexVariables returns the numbered and named groups of a regex match,
+// for use in templates.
+func regexVariables(regex *regexp.Regexp, submatches []string) map[string]string {
+	vars := make(map[string]string, len(submatches))
+	for i, name := range regex.SubexpNames() {
+		vars[strconv.Itoa(i)] = submatches[i]
+		if name != "" {
+			vars[name] = submatches[i]
+		}
+	}
+	return vars
+}
+
+// expandTemplate substitutes ${var} in a template line; unknown variables
+// are left as-is.
+func expandTemplate(template string, vars map[string]string) string {
+	return scriptVariableRegex.ReplaceAllStringFunc(template, func(match string) string {
+		if value, ok := vars[match[2:len(match)-1]]; ok {
+			return value
+		}
+		return match
+	})
+}
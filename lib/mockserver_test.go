@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func actionLines(actions []MockServerAction) (lines []string) {
+	for _, action := range actions {
+		if action.Line != "" {
+			lines = append(lines, action.Line)
+		}
+	}
+	return
+}
+
+func TestMockServerRegistration(t *testing.T) {
+	s := NewMockServerSession("irc.test", nil)
+	assertLines(t, actionLines(s.HandleLine("CAP LS 302")), ":irc.test CAP * LS :")
+	assertLines(t, actionLines(s.HandleLine("NICK dog")))
+	assertLines(t, actionLines(s.HandleLine("USER u 0 * :Real Name")))
+	lines := actionLines(s.HandleLine("CAP END"))
+	if len(lines) != 6 || lines[0] != ":irc.test 001 dog :Welcome to the ircdog mock server, dog" {
+		t.Errorf("bad registration burst: %#v", lines)
+	}
+	assertLines(t, actionLines(s.HandleLine("PING :abc")), ":irc.test PONG irc.test abc")
+	assertLines(t, actionLines(s.HandleLine("NICK cat")), ":dog!u@ircdog NICK cat")
+	assertLines(t, actionLines(s.HandleLine("PRIVMSG #chan :hi")))
+}
+
+func TestMockServerRules(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules")
+	err := os.WriteFile(rulesFile, []byte(`# comment
+/^PRIVMSG (\S+) :!echo (?P<text>.*)/
+	:${nick}!u@ircdog PRIVMSG ${1} :${text} ${unknown}
+
+/^QUIT/
+    !sleep 50ms
+    !disconnect Bye ${nick}
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := ReadMockServerRules(rulesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewMockServerSession("irc.test", rules)
+	assertLines(t, actionLines(s.HandleLine("PRIVMSG #chan :!echo hello world")), ":*!u@ircdog PRIVMSG #chan :hello world ${unknown}")
+	actions := s.HandleLine("QUIT")
+	expected := []MockServerAction{{Delay: 50 * time.Millisecond}, {Line: "ERROR :Bye *"}, {Disconnect: true}}
+	if len(actions) != 3 || actions[0] != expected[0] || actions[1] != expected[1] || actions[2] != expected[2] {
+		t.Errorf("expected %#v, got %#v", expected, actions)
+	}
+
+	os.WriteFile(rulesFile, []byte("\t:orphan action\n"), 0600)
+	if _, err := ReadMockServerRules(rulesFile); err == nil {
+		t.Errorf("accepted action outside of a rule")
+	}
+}
@@ -0,0 +1,245 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The PROXY protocol lets a load balancer like HAProxy tell the server the
+// real address of a client, in a header sent before any other data:
+// https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+
+const (
+	// clients of a listener must send their PROXY header within this long
+	ProxyHeaderTimeout = 10 * time.Second
+
+	// a v1 header is a single line of at most 107 bytes, including \r\n
+	maxProxyHeaderV1Length = 107
+)
+
+var (
+	proxyHeaderV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+)
+
+// ProxyHeaderConfig describes a PROXY protocol header to send right after
+// connecting, as a load balancer would.
+type ProxyHeaderConfig struct {
+	// 1 for the text format, or 2 for the binary format
+	Version int
+	// the client address to claim; if nil, the real local address is used
+	Source *net.TCPAddr
+}
+
+// writeProxyHeader writes a PROXY header claiming that the connection is
+// from source to destination. If source isn't a TCP address, the header says
+// that the address is unknown; if destination isn't a TCP address of the
+// same family, an unspecified address is sent in its place.
+func writeProxyHeader(w io.Writer, version int, source, destination net.Addr) error {
+	src, _ := source.(*net.TCPAddr)
+	dst, _ := destination.(*net.TCPAddr)
+	isIPv4 := src != nil && src.IP.To4() != nil
+	if src != nil && (dst == nil || (dst.IP.To4() != nil) != isIPv4) {
+		dst = &net.TCPAddr{IP: net.IPv6unspecified}
+		if isIPv4 {
+			dst.IP = net.IPv4zero
+		}
+	}
+
+	var header []byte
+	switch version {
+	case 1:
+		if src == nil {
+			header = []byte("PROXY UNKNOWN\r\n")
+		} else {
+			family := "TCP6"
+			if isIPv4 {
+				family = "TCP4"
+			}
+			header = []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, src.IP, dst.IP, src.Port, dst.Port))
+		}
+	case 2:
+		header = append(header, proxyHeaderV2Signature...)
+		// version 2, PROXY command
+		header = append(header, 0x21)
+		var addresses []byte
+		switch {
+		case src == nil:
+			// AF_UNSPEC
+			header = append(header, 0x00)
+		case isIPv4:
+			// AF_INET, STREAM
+			header = append(header, 0x11)
+			addresses = append(addresses, src.IP.To4()...)
+			addresses = append(addresses, dst.IP.To4()...)
+		default:
+			// AF_INET6, STREAM
+			header = append(header, 0x21)
+			addresses = append(addresses, src.IP.To16()...)
+			addresses = append(addresses, dst.IP.To16()...)
+		}
+		if src != nil {
+			addresses = binary.BigEndian.AppendUint16(addresses, uint16(src.Port))
+			addresses = binary.BigEndian.AppendUint16(addresses, uint16(dst.Port))
+		}
+		header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+		header = append(header, addresses...)
+	default:
+		return fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+	_, err := w.Write(header)
+	return err
+}
+
+// readProxyHeader reads a v1 or v2 PROXY header. source is nil if the header
+// doesn't carry a client address (e.g., a health check by the load balancer).
+func readProxyHeader(reader *bufio.Reader) (source net.Addr, err error) {
+	if start, _ := reader.Peek(len(proxyHeaderV2Signature)); bytes.Equal(start, proxyHeaderV2Signature) {
+		return readProxyHeaderV2(reader)
+	}
+	return readProxyHeaderV1(reader)
+}
+
+func readProxyHeaderV1(reader *bufio.Reader) (source net.Addr, err error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == maxProxyHeaderV1Length {
+			return nil, ErrInvalidProxyHeader
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrInvalidProxyHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, portErr := strconv.Atoi(fields[4])
+	if ip == nil || (ip.To4() != nil) != (fields[1] == "TCP4") || portErr != nil || port < 0 || port > 65535 {
+		return nil, ErrInvalidProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyHeaderV2(reader *bufio.Reader) (source net.Addr, err error) {
+	fixed := make([]byte, len(proxyHeaderV2Signature)+4)
+	if _, err = io.ReadFull(reader, fixed); err != nil {
+		return
+	}
+	versionCommand, family := fixed[12], fixed[13]
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err = io.ReadFull(reader, body); err != nil {
+		return
+	}
+	if versionCommand>>4 != 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+	switch versionCommand & 0xf {
+	case 0:
+		// LOCAL: the load balancer connected on its own behalf
+		return nil, nil
+	case 1:
+		// PROXY
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+	var ipLength int
+	switch family >> 4 {
+	case 1:
+		ipLength = net.IPv4len
+	case 2:
+		ipLength = net.IPv6len
+	default:
+		// AF_UNSPEC or AF_UNIX: there is no IP address to show
+		return nil, nil
+	}
+	if len(body) < 2*ipLength+4 {
+		return nil, ErrInvalidProxyHeader
+	}
+	// any TLVs after the addresses are ignored
+	return &net.TCPAddr{
+		IP:   net.IP(body[:ipLength]),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLength:])),
+	}, nil
+}
+
+// proxyHeaderConn reads a PROXY header from an accepted connection, on the
+// first call to Read or RemoteAddr, so that a slow client doesn't hold up
+// the accept loop. RemoteAddr then returns the address from the header.
+type proxyHeaderConn struct {
+	net.Conn
+	log func(string)
+
+	once   sync.Once
+	reader *bufio.Reader
+	source net.Addr
+	err    error
+}
+
+func (c *proxyHeaderConn) readHeader() {
+	c.reader = bufio.NewReader(c.Conn)
+	c.Conn.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout))
+	c.source, c.err = readProxyHeader(c.reader)
+	c.Conn.SetReadDeadline(time.Time{})
+	peer := c.Conn.RemoteAddr().String()
+	switch {
+	case c.err == ErrInvalidProxyHeader:
+		c.log(fmt.Sprintf("received an invalid PROXY header from %s", peer))
+	case c.err != nil:
+		c.log(fmt.Sprintf("couldn't read PROXY header from %s: %v", peer, c.err))
+		c.err = fmt.Errorf("couldn't read PROXY header: %w", c.err)
+	case c.source == nil:
+		c.log(fmt.Sprintf("PROXY header from %s has no client address", peer))
+	default:
+		c.log(fmt.Sprintf("PROXY header from %s has client address %s", peer, c.source))
+	}
+}
+
+func (c *proxyHeaderConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyHeaderConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// proxyHeaderListener wraps each accepted connection in a proxyHeaderConn.
+type proxyHeaderListener struct {
+	net.Listener
+	log func(string)
+}
+
+func (l *proxyHeaderListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyHeaderConn{Conn: conn, log: l.log}, nil
+}
@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestProxyHeaderRoundTrip(t *testing.T) {
+	ipv4 := &net.TCPAddr{IP: net.ParseIP("203.0.113.5"), Port: 4000}
+	ipv6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::5"), Port: 4001}
+	server := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 6667}
+	for _, version := range []int{1, 2} {
+		for _, source := range []*net.TCPAddr{ipv4, ipv6, nil} {
+			var buf bytes.Buffer
+			var sourceAddr net.Addr
+			if source != nil {
+				sourceAddr = source
+			}
+			if err := writeProxyHeader(&buf, version, sourceAddr, server); err != nil {
+				t.Fatal(err)
+			}
+			buf.WriteString("NICK a\r\n")
+			reader := bufio.NewReader(&buf)
+			result, err := readProxyHeader(reader)
+			if err != nil {
+				t.Fatalf("v%d %v: %v", version, source, err)
+			}
+			if source == nil {
+				if result != nil {
+					t.Errorf("v%d: expected no address, got %v", version, result)
+				}
+			} else if result == nil || result.String() != source.String() {
+				t.Errorf("v%d: expected %v, got %v", version, source, result)
+			}
+			if rest, _ := reader.ReadString('\n'); rest != "NICK a\r\n" {
+				t.Errorf("v%d: header consumed too much: %q", version, rest)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	writeProxyHeader(&buf, 1, ipv6, server)
+	if expected := "PROXY TCP6 2001:db8::5 :: 4001 0\r\n"; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestInvalidProxyHeader(t *testing.T) {
+	for _, header := range []string{
+		"NICK a\r\n",
+		"PROXY TCP4 203.0.113.5 192.0.2.1 4000\r\n",
+		"PROXY TCP4 2001:db8::5 192.0.2.1 4000 6667\r\n",
+		"PROXY TCP4 203.0.113.5 192.0.2.1 4000 6667" + strings.Repeat(" ", 100) + "\r\n",
+		string(proxyHeaderV2Signature) + "\x31\x11\x00\x00",
+	} {
+		if _, err := readProxyHeader(bufio.NewReader(strings.NewReader(header))); err != ErrInvalidProxyHeader {
+			t.Errorf("expected %q to be invalid, got %v", header, err)
+		}
+	}
+}
+
+func TestListenProxyProtocolTLS(t *testing.T) {
+	cert, err := GenerateSelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged []string
+	ln, err := Listen("127.0.0.1:0", ListenerConfig{
+		TLSConfig:     &tls.Config{Certificates: []tls.Certificate{cert}},
+		ProxyProtocol: true,
+		Log:           func(message string) { logged = append(logged, message) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	source := &net.TCPAddr{IP: net.ParseIP("203.0.113.5"), Port: 4000}
+	go func() {
+		addr := ln.Addr().(*net.TCPAddr)
+		conn, err := ConnectSocket(ConnectionConfig{
+			Host:        "127.0.0.1",
+			Port:        addr.Port,
+			TLS:         true,
+			TLSConfig:   &tls.Config{InsecureSkipVerify: true},
+			ProxyHeader: &ProxyHeaderConfig{Version: 2, Source: source},
+		})
+		if err == nil {
+			conn.SendLine("PING x")
+			conn.Disconnect()
+		}
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+	if remote := conn.RemoteAddr().String(); remote != source.String() {
+		t.Errorf("expected address %s, got %s", source, remote)
+	}
+	if len(logged) != 1 || !strings.Contains(logged[0], source.String()) {
+		t.Errorf("bad log messages: %v", logged)
+	}
+	line, err := conn.GetLine()
+	if err != nil || line != "PING x" {
+		t.Errorf("bad line %q: %v", line, err)
+	}
+}
@@ -0,0 +1,214 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig describes a token bucket: up to Burst lines can be sent
+// at once, after which one more can be sent every Interval.
+type RateLimitConfig struct {
+	Burst    int
+	Interval time.Duration
+}
+
+// ParseRateLimit parses a rate limit like "5/1s" (a burst of 5 lines,
+// then one per second).
+func ParseRateLimit(input string) (config RateLimitConfig, err error) {
+	burstStr, intervalStr, found := strings.Cut(input, "/")
+	if !found {
+		return config, fmt.Errorf("expected <burst>/<interval>, e.g. 5/1s")
+	}
+	config.Burst, err = strconv.Atoi(burstStr)
+	if err != nil || config.Burst < 1 {
+		return config, fmt.Errorf("invalid burst `%s`", burstStr)
+	}
+	config.Interval, err = time.ParseDuration(intervalStr)
+	if err != nil || config.Interval <= 0 {
+		return config, fmt.Errorf("invalid interval `%s`", intervalStr)
+	}
+	return config, nil
+}
+
+// RateLimitedConnection wraps an IRCConnection, queueing the lines passed
+// to SendLine so that they are written no faster than the rate limit, to
+// avoid being disconnected for flooding. Lines are written in order, by a
+// separate goroutine; if a write fails, the connection is closed, and
+// subsequent calls to SendLine return the error.
+type RateLimitedConnection struct {
+	IRCConnection
+	config RateLimitConfig
+	// if set, called with the number of queued lines whenever it changes
+	OnQueue func(int)
+
+	mutex sync.Mutex
+	// signalled when a line is sent, or the connection is closed
+	sent   *sync.Cond
+	queue  []queuedLine
+	tokens int
+	// when tokens were last added to the bucket
+	refilled time.Time
+	err      error
+
+	wake      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type queuedLine struct {
+	line string
+	sent func()
+}
+
+func NewRateLimitedConnection(conn IRCConnection, config RateLimitConfig) *RateLimitedConnection {
+	result := &RateLimitedConnection{
+		IRCConnection: conn,
+		config:        config,
+		tokens:        config.Burst,
+		refilled:      time.Now(),
+		wake:          make(chan struct{}, 1),
+		closed:        make(chan struct{}),
+	}
+	result.sent = sync.NewCond(&result.mutex)
+	go result.run()
+	return result
+}
+
+// SendLine queues a line to be sent.
+func (r *RateLimitedConnection) SendLine(line string) error {
+	return r.QueueLine(line, nil)
+}
+
+// QueueLine queues a line to be sent; if sent is non-nil, it is called
+// from the sending goroutine once the line has been written successfully,
+// so that the line can be logged at the time it was actually sent.
+func (r *RateLimitedConnection) QueueLine(line string, sent func()) error {
+	r.mutex.Lock()
+	if r.err != nil {
+		r.mutex.Unlock()
+		return r.err
+	}
+	r.queue = append(r.queue, queuedLine{line: line, sent: sent})
+	queued := len(r.queue)
+	r.mutex.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	r.notify(queued)
+	return nil
+}
+
+// Queued returns the number of lines waiting to be sent.
+func (r *RateLimitedConnection) Queued() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.queue)
+}
+
+// Flush waits until all queued lines have been sent, or the connection
+// is closed.
+func (r *RateLimitedConnection) Flush() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for len(r.queue) != 0 && r.err == nil && !r.isClosed() {
+		r.sent.Wait()
+	}
+}
+
+// Disconnect closes the connection, discarding any queued lines.
+func (r *RateLimitedConnection) Disconnect() {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		r.mutex.Lock()
+		r.sent.Broadcast()
+		r.mutex.Unlock()
+	})
+	r.IRCConnection.Disconnect()
+}
+
+func (r *RateLimitedConnection) isClosed() bool {
+	select {
+	case <-r.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *RateLimitedConnection) notify(queued int) {
+	if r.OnQueue != nil {
+		r.OnQueue(queued)
+	}
+}
+
+func (r *RateLimitedConnection) run() {
+	for {
+		line, wait, ok := r.next(time.Now())
+		if !ok {
+			select {
+			case <-r.wake:
+				continue
+			case <-r.closed:
+				return
+			}
+		}
+		if wait != 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+				continue
+			case <-r.closed:
+				timer.Stop()
+				return
+			}
+		}
+
+		err := r.IRCConnection.SendLine(line.line)
+		if err == nil && line.sent != nil {
+			line.sent()
+		}
+		r.mutex.Lock()
+		r.queue = r.queue[1:]
+		queued := len(r.queue)
+		if err != nil {
+			r.err = err
+			r.queue = nil
+		}
+		r.sent.Broadcast()
+		r.mutex.Unlock()
+		r.notify(queued)
+		if err != nil {
+			r.Disconnect()
+			return
+		}
+	}
+}
+
+// next returns the next line to send, if any, and how long to wait before
+// sending it; if it can be sent now, a token is used.
+func (r *RateLimitedConnection) next(now time.Time) (line queuedLine, wait time.Duration, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.queue) == 0 {
+		return line, 0, false
+	}
+	if elapsed := now.Sub(r.refilled); r.tokens < r.config.Burst && elapsed >= r.config.Interval {
+		added := int(elapsed / r.config.Interval)
+		r.tokens += added
+		r.refilled = r.refilled.Add(time.Duration(added) * r.config.Interval)
+	}
+	if r.tokens >= r.config.Burst {
+		r.tokens = r.config.Burst
+		r.refilled = now
+	}
+	if r.tokens == 0 {
+		return line, r.refilled.Add(r.config.Interval).Sub(now), true
+	}
+	r.tokens--
+	return r.queue[0], 0, true
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	config, err := ParseRateLimit("5/500ms")
+	if err != nil || config.Burst != 5 || config.Interval != 500*time.Millisecond {
+		t.Errorf("bad rate limit %#v: %v", config, err)
+	}
+	for _, invalid := range []string{"5", "0/1s", "5/0s", "x/1s", "5/1"} {
+		if _, err := ParseRateLimit(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestRateLimitedConnection(t *testing.T) {
+	conn := new(rawRecorder)
+	interval := 50 * time.Millisecond
+	r := NewRateLimitedConnection(conn, RateLimitConfig{Burst: 3, Interval: interval})
+	defer r.Disconnect()
+
+	start := time.Now()
+	for _, line := range []string{"1", "2", "3", "4"} {
+		if err := r.SendLine(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the callback runs once the line is actually written
+	writtenWhenSent := make(chan int, 1)
+	if err := r.QueueLine("5", func() { writtenWhenSent <- len(conn.getWrites()) }); err != nil {
+		t.Fatal(err)
+	}
+	// the burst is sent immediately
+	time.Sleep(interval / 2)
+	assertLines(t, conn.getWrites(), "1\r\n", "2\r\n", "3\r\n")
+	if queued := r.Queued(); queued != 2 {
+		t.Errorf("expected 2 queued lines, got %d", queued)
+	}
+
+	r.Flush()
+	if elapsed := time.Since(start); elapsed < 2*interval {
+		t.Errorf("queue drained too quickly, after %v", elapsed)
+	}
+	assertLines(t, conn.getWrites(), "1\r\n", "2\r\n", "3\r\n", "4\r\n", "5\r\n")
+	select {
+	case written := <-writtenWhenSent:
+		if written != 5 {
+			t.Errorf("callback ran after %d writes, expected 5", written)
+		}
+	default:
+		t.Errorf("expected the callback to have run")
+	}
+}
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"math/rand"
+	"regexp"
+	"time"
+)
+
+const (
+	// a connection that lasts this long resets the backoff and attempt count
+	StableConnectionTime = time.Minute
+)
+
+var (
+	// ERROR reasons that usually mean reconnecting is pointless
+	DefaultNoReconnectPattern = regexp.MustCompile(`(?i)banned|[kgzd]-?lined`)
+)
+
+// ReconnectConfig describes how ircdog reconnects after an unexpected
+// disconnection.
+type ReconnectConfig struct {
+	// pause before the first attempt; each consecutive failure doubles it,
+	// up to MaxDelay (if MaxDelay <= Delay, the pause is fixed)
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// each pause is randomly varied by up to this fraction of it, e.g. 0.2
+	Jitter float64
+	// if nonzero, give up after this many consecutive failed attempts
+	MaxAttempts int
+	// if the server's ERROR message matches, don't reconnect
+	NoReconnect *regexp.Regexp
+}
+
+// Reconnector implements the backoff for a ReconnectConfig.
+type Reconnector struct {
+	config   ReconnectConfig
+	rand     *rand.Rand
+	attempts int
+}
+
+func NewReconnector(config ReconnectConfig) *Reconnector {
+	return &Reconnector{
+		config: config,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// ShouldReconnect returns whether an ERROR reason from the server permits
+// reconnecting.
+func (r *Reconnector) ShouldReconnect(errorReason string) bool {
+	return errorReason == "" || r.config.NoReconnect == nil || !r.config.NoReconnect.MatchString(errorReason)
+}
+
+// Next is called after a disconnection, with how long the connection lasted
+// (zero if it failed outright). It returns how long to wait before the next
+// attempt, or ok=false if the attempts are exhausted.
+func (r *Reconnector) Next(connectedFor time.Duration) (delay time.Duration, attempt int, ok bool) {
+	if connectedFor >= StableConnectionTime {
+		r.attempts = 0
+	}
+	r.attempts++
+	if r.config.MaxAttempts != 0 && r.attempts > r.config.MaxAttempts {
+		return 0, r.attempts, false
+	}
+	delay = r.config.Delay
+	for i := 1; i < r.attempts && delay < r.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if r.config.MaxDelay > r.config.Delay && delay > r.config.MaxDelay {
+		delay = r.config.MaxDelay
+	}
+	if r.config.Jitter != 0 {
+		delay += time.Duration(float64(delay) * r.config.Jitter * (2*r.rand.Float64() - 1))
+	}
+	return delay, r.attempts, true
+}
@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	r := NewReconnector(ReconnectConfig{Delay: time.Second, MaxDelay: 10 * time.Second, MaxAttempts: 6})
+	for i, expected := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		delay, attempt, ok := r.Next(0)
+		if !ok || attempt != i+1 || delay != expected*time.Second {
+			t.Errorf("attempt %d: expected %v, got %v (%d, %t)", i+1, expected*time.Second, delay, attempt, ok)
+		}
+	}
+	if _, _, ok := r.Next(time.Second); ok {
+		t.Errorf("expected attempts to be exhausted")
+	}
+
+	// a stable connection resets the backoff
+	r = NewReconnector(ReconnectConfig{Delay: time.Second, MaxDelay: 10 * time.Second, MaxAttempts: 2})
+	r.Next(0)
+	r.Next(0)
+	if delay, attempt, ok := r.Next(StableConnectionTime); !ok || attempt != 1 || delay != time.Second {
+		t.Errorf("expected backoff to be reset, got %v (%d, %t)", delay, attempt, ok)
+	}
+
+	// without a maximum, the pause is fixed, and attempts are unlimited
+	r = NewReconnector(ReconnectConfig{Delay: 5 * time.Second})
+	for i := 0; i < 100; i++ {
+		if delay, _, ok := r.Next(0); !ok || delay != 5*time.Second {
+			t.Fatalf("expected a fixed pause, got %v (%t)", delay, ok)
+		}
+	}
+}
+
+func TestReconnectJitter(t *testing.T) {
+	r := NewReconnector(ReconnectConfig{Delay: 10 * time.Second, Jitter: 0.2})
+	varied := false
+	for i := 0; i < 100; i++ {
+		delay, _, _ := r.Next(0)
+		if delay < 8*time.Second || delay > 12*time.Second {
+			t.Fatalf("delay %v is out of range", delay)
+		}
+		varied = varied || delay != 10*time.Second
+	}
+	if !varied {
+		t.Errorf("expected jitter to vary the delay")
+	}
+}
+
+func TestShouldReconnect(t *testing.T) {
+	r := NewReconnector(ReconnectConfig{NoReconnect: DefaultNoReconnectPattern})
+	for reason, expected := range map[string]bool{
+		"": true,
+		"Closing Link: example (Ping timeout: 240 seconds)": true,
+		"Closing Link: example (K-Lined)":                   false,
+		"You are banned from this server (spam)":            false,
+		"Closing Link: example (G-lined: flooding)":         false,
+	} {
+		if r.ShouldReconnect(reason) != expected {
+			t.Errorf("expected ShouldReconnect(%q) to be %t", reason, expected)
+		}
+	}
+}
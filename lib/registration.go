@@ -0,0 +1,427 @@
+package lib
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	// how to pick a new nickname when the server rejects ours
+	NickFallbackUnderscore = "underscore"
+	NickFallbackDigits     = "digits"
+	NickFallbackNone       = "none"
+
+	maxNickAttempts = 10
+
+	// keep CAP REQ lines comfortably under the 512-byte limit
+	maxCapReqLength = 400
+)
+
+// RegistrationConfig holds the parameters for automatic client registration.
+type RegistrationConfig struct {
+	Nick     string
+	User     string
+	Realname string
+	Password string
+	// one of the NickFallback constants
+	NickFallback string
+	// IRCv3 capabilities to request; if empty, CAP negotiation is skipped
+	Caps []string
+	// SASL mechanism (one of the SASL constants) and credentials; if set,
+	// the sasl capability is requested automatically
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+	// if SASLRequired is set, failing to authenticate is a fatal error
+	SASLRequired bool
+	// if set, WEBIRC is sent before anything else
+	WebIRC *WebIRCConfig
+}
+
+// Registrar drives connection registration (including IRCv3 capability
+// negotiation and SASL) on behalf of the user. It does no I/O of its own: it returns
+// the lines that should be sent, and the caller passes in every message
+// received from the server. A new Registrar must be created for each connection.
+// If no nickname is configured, NICK and USER are left to the user.
+type Registrar struct {
+	config RegistrationConfig
+	// Log, if set, receives human-readable notes on the registration process
+	Log func(string)
+
+	nick         string
+	nickAttempts int
+	registered   bool
+
+	// CAP negotiation state:
+	capNegotiating bool
+	capLSDone      bool
+	pendingCapReqs int
+	serverCaps     map[string]string // capability name to value, per CAP LS
+	enabledCaps    map[string]bool
+
+	// SASL state:
+	sasl        saslMechanism
+	saslBuf     strings.Builder // accumulates chunked AUTHENTICATE payloads
+	saslStarted bool
+	saslDone    bool
+}
+
+var (
+	ErrSASLFailed = errors.New("SASL authentication failed")
+)
+
+func NewRegistrar(config RegistrationConfig) *Registrar {
+	if config.User == "" {
+		config.User = config.Nick
+	}
+	if config.Realname == "" {
+		config.Realname = "ircdog"
+	}
+	if config.NickFallback == "" {
+		config.NickFallback = NickFallbackUnderscore
+	}
+	if config.SASLMechanism != "" {
+		hasSASL := false
+		for _, capName := range config.Caps {
+			hasSASL = hasSASL || capName == "sasl"
+		}
+		if !hasSASL {
+			config.Caps = append(config.Caps, "sasl")
+		}
+	}
+	return &Registrar{
+		config:      config,
+		nick:        config.Nick,
+		serverCaps:  make(map[string]string),
+		enabledCaps: make(map[string]bool),
+	}
+}
+
+// Start returns the initial lines to send when the connection opens.
+func (r *Registrar) Start() (lines []string) {
+	if r.config.WebIRC != nil {
+		lines = append(lines, r.config.WebIRC.Line())
+	}
+	if len(r.config.Caps) != 0 {
+		r.capNegotiating = true
+		lines = append(lines, "CAP LS 302")
+	}
+	if r.config.Password != "" {
+		lines = append(lines, makeLine("PASS", r.config.Password))
+	}
+	if r.config.Nick != "" {
+		lines = append(lines, makeLine("NICK", r.nick))
+		lines = append(lines, makeLine("USER", r.config.User, "0", "*", r.config.Realname))
+	}
+	return
+}
+
+// Registered returns whether the server has accepted our registration (RPL_WELCOME).
+func (r *Registrar) Registered() bool {
+	return r.registered
+}
+
+// Nick returns the nickname we most recently attempted to register with,
+// or the nickname assigned by the server if registration succeeded.
+func (r *Registrar) Nick() string {
+	return r.nick
+}
+
+// ServerCaps returns the capabilities advertised by the server (via CAP LS
+// and CAP NEW), mapped to their values. It must not be modified.
+func (r *Registrar) ServerCaps() map[string]string {
+	return r.serverCaps
+}
+
+// CapEnabled returns whether the server has acknowledged the capability.
+func (r *Registrar) CapEnabled(capName string) bool {
+	return r.enabledCaps[capName]
+}
+
+// HandleMessage processes a message from the server, returning any
+// lines that should be sent in response.
+func (r *Registrar) HandleMessage(msg ircmsg.Message) (lines []string, err error) {
+	switch msg.Command {
+	case "001":
+		r.registered = true
+		r.capNegotiating = false
+		if len(msg.Params) != 0 {
+			r.nick = msg.Params[0]
+		}
+	case "CAP":
+		lines, err = r.handleCap(msg)
+	case "AUTHENTICATE":
+		lines, err = r.handleAuthenticate(msg)
+	case "900": // RPL_LOGGEDIN
+		if len(msg.Params) > 2 {
+			r.log(fmt.Sprintf("SASL: logged in as %s", msg.Params[2]))
+		}
+	case "901": // RPL_LOGGEDOUT
+		r.log("SASL: logged out")
+	case "903": // RPL_SASLSUCCESS
+		if r.saslStarted && !r.saslDone {
+			r.log("SASL authentication succeeded")
+			lines = r.finishSASL()
+		}
+	case "902", "904", "905", "906", "907": // ERR_NICKLOCKED, ERR_SASLFAIL, ERR_SASLTOOLONG, ERR_SASLABORTED, ERR_SASLALREADY
+		if r.saslStarted && !r.saslDone {
+			reason := msg.Command
+			if len(msg.Params) > 1 {
+				reason = fmt.Sprintf("%s %s", msg.Command, msg.Params[len(msg.Params)-1])
+			}
+			lines, err = r.failSASL(reason)
+		}
+	case "908": // RPL_SASLMECHS
+		if len(msg.Params) > 1 {
+			r.log(fmt.Sprintf("SASL: server supports mechanisms %s", msg.Params[1]))
+		}
+	case "410", "421": // ERR_INVALIDCAPCMD, ERR_UNKNOWNCOMMAND
+		if r.capNegotiating && len(msg.Params) > 1 && strings.ToUpper(msg.Params[1]) == "CAP" {
+			r.log("server does not support CAP negotiation")
+			r.capNegotiating = false
+			if r.config.SASLMechanism != "" && !r.saslStarted {
+				r.saslStarted = true
+				_, err = r.failSASL("server does not support CAP negotiation")
+			}
+		}
+	case "432", "433", "436": // ERR_ERRONEUSNICKNAME, ERR_NICKNAMEINUSE, ERR_NICKCOLLISION
+		if r.registered || r.config.Nick == "" {
+			return
+		}
+		newNick := r.fallbackNick()
+		if newNick == "" {
+			r.log(fmt.Sprintf("nickname %s was rejected (%s), giving up", r.nick, msg.Command))
+			return
+		}
+		r.log(fmt.Sprintf("nickname %s was rejected (%s), trying %s", r.nick, msg.Command, newNick))
+		r.nick = newNick
+		lines = append(lines, makeLine("NICK", r.nick))
+	}
+	return
+}
+
+func (r *Registrar) handleCap(msg ircmsg.Message) (lines []string, err error) {
+	if len(msg.Params) < 3 {
+		return
+	}
+	subcommand := strings.ToUpper(msg.Params[1])
+	// the capability list is always the final parameter; a preceding `*`
+	// indicates that more lines are coming
+	capList := strings.Fields(msg.Params[len(msg.Params)-1])
+	continued := len(msg.Params) > 3 && msg.Params[2] == "*"
+
+	switch subcommand {
+	case "LS":
+		if r.capLSDone {
+			// a repeated LS, e.g. typed by the user; we only negotiate once
+			return
+		}
+		for _, capability := range capList {
+			name, value, _ := strings.Cut(capability, "=")
+			r.serverCaps[name] = value
+		}
+		if continued {
+			return
+		}
+		r.capLSDone = true
+		if !r.capNegotiating {
+			return
+		}
+		r.log(fmt.Sprintf("server offers %d capabilities", len(r.serverCaps)))
+		var toRequest, unavailable []string
+		for _, capName := range r.config.Caps {
+			if _, ok := r.serverCaps[capName]; ok {
+				toRequest = append(toRequest, capName)
+			} else {
+				unavailable = append(unavailable, capName)
+			}
+		}
+		if len(unavailable) != 0 {
+			r.log(fmt.Sprintf("requested capabilities not offered by server: %s", strings.Join(unavailable, " ")))
+		}
+		lines = r.requestCaps(toRequest)
+		if r.pendingCapReqs == 0 {
+			var finishLines []string
+			finishLines, err = r.finishCapNegotiation()
+			lines = append(lines, finishLines...)
+		}
+	case "ACK", "NAK":
+		if subcommand == "ACK" {
+			for _, capName := range capList {
+				if strings.HasPrefix(capName, "-") {
+					delete(r.enabledCaps, capName[1:])
+				} else {
+					r.enabledCaps[capName] = true
+				}
+			}
+			r.log(fmt.Sprintf("capabilities acknowledged: %s", strings.Join(capList, " ")))
+		} else {
+			r.log(fmt.Sprintf("capabilities rejected: %s", strings.Join(capList, " ")))
+		}
+		if continued || r.pendingCapReqs == 0 {
+			return
+		}
+		r.pendingCapReqs--
+		if r.pendingCapReqs == 0 && r.capNegotiating {
+			lines, err = r.finishCapNegotiation()
+		}
+	case "NEW":
+		var toRequest []string
+		for _, capability := range capList {
+			name, value, _ := strings.Cut(capability, "=")
+			r.serverCaps[name] = value
+			for _, wanted := range r.config.Caps {
+				if name == wanted && !r.enabledCaps[name] {
+					toRequest = append(toRequest, name)
+				}
+			}
+		}
+		r.log(fmt.Sprintf("server added capabilities: %s", strings.Join(capList, " ")))
+		lines = r.requestCaps(toRequest)
+	case "DEL":
+		for _, capName := range capList {
+			delete(r.serverCaps, capName)
+			delete(r.enabledCaps, capName)
+		}
+		r.log(fmt.Sprintf("server removed capabilities: %s", strings.Join(capList, " ")))
+	}
+	return
+}
+
+// requestCaps builds CAP REQ lines for the capabilities, splitting them
+// as necessary to respect the line length limit
+func (r *Registrar) requestCaps(capNames []string) (lines []string) {
+	var buf []string
+	bufLen := 0
+	flush := func() {
+		if len(buf) != 0 {
+			lines = append(lines, makeLine("CAP", "REQ", strings.Join(buf, " ")))
+			r.pendingCapReqs++
+			buf = buf[:0]
+			bufLen = 0
+		}
+	}
+	for _, capName := range capNames {
+		if bufLen+len(capName)+1 > maxCapReqLength {
+			flush()
+		}
+		buf = append(buf, capName)
+		bufLen += len(capName) + 1
+	}
+	flush()
+	return
+}
+
+// finishCapNegotiation is called once all our CAP REQ have been answered;
+// it starts SASL if applicable, otherwise it ends negotiation.
+func (r *Registrar) finishCapNegotiation() (lines []string, err error) {
+	if r.config.SASLMechanism != "" && !r.saslStarted {
+		r.saslStarted = true
+		if !r.enabledCaps["sasl"] {
+			return r.failSASL("server did not enable the sasl capability")
+		}
+		if mechs, ok := r.serverCaps["sasl"]; ok && mechs != "" {
+			offered := false
+			for _, mech := range strings.Split(mechs, ",") {
+				offered = offered || strings.EqualFold(mech, r.config.SASLMechanism)
+			}
+			if !offered {
+				r.log(fmt.Sprintf("SASL: server does not advertise %s (only %s), trying anyway", r.config.SASLMechanism, mechs))
+			}
+		}
+		r.sasl, err = newSASLMechanism(r.config.SASLMechanism, r.config.SASLUsername, r.config.SASLPassword)
+		if err != nil {
+			return r.failSASL(err.Error())
+		}
+		return []string{makeLine("AUTHENTICATE", r.config.SASLMechanism)}, nil
+	}
+	r.capNegotiating = false
+	return []string{"CAP END"}, nil
+}
+
+func (r *Registrar) handleAuthenticate(msg ircmsg.Message) (lines []string, err error) {
+	if r.sasl == nil || r.saslDone || len(msg.Params) == 0 {
+		return
+	}
+	chunk := msg.Params[0]
+	if chunk != "+" {
+		r.saslBuf.WriteString(chunk)
+	}
+	if len(chunk) == saslChunkSize {
+		// more chunks are coming
+		return
+	}
+	challenge, decodeErr := base64.StdEncoding.DecodeString(r.saslBuf.String())
+	r.saslBuf.Reset()
+	if decodeErr != nil {
+		lines = append(lines, "AUTHENTICATE *")
+		failLines, err := r.failSASL("server sent invalid base64")
+		return append(lines, failLines...), err
+	}
+	response, mechErr := r.sasl.Next(challenge)
+	if mechErr != nil {
+		lines = append(lines, "AUTHENTICATE *")
+		failLines, err := r.failSASL(mechErr.Error())
+		return append(lines, failLines...), err
+	}
+	return encodeAuthenticate(response), nil
+}
+
+func (r *Registrar) finishSASL() (lines []string) {
+	r.saslDone = true
+	if r.capNegotiating {
+		r.capNegotiating = false
+		lines = append(lines, "CAP END")
+	}
+	return
+}
+
+// failSASL reports a SASL failure; if SASL is required, it returns an error,
+// otherwise it continues with registration.
+func (r *Registrar) failSASL(reason string) (lines []string, err error) {
+	r.log(fmt.Sprintf("SASL authentication failed: %s", reason))
+	if r.config.SASLRequired {
+		r.saslDone = true
+		return nil, ErrSASLFailed
+	}
+	return r.finishSASL(), nil
+}
+
+func (r *Registrar) fallbackNick() string {
+	if r.nickAttempts >= maxNickAttempts {
+		return ""
+	}
+	r.nickAttempts++
+	switch r.config.NickFallback {
+	case NickFallbackUnderscore:
+		return r.nick + "_"
+	case NickFallbackDigits:
+		return r.config.Nick + strconv.Itoa(r.nickAttempts)
+	default:
+		return ""
+	}
+}
+
+func (r *Registrar) log(message string) {
+	if r.Log != nil {
+		r.Log(message)
+	}
+}
+
+// makeLine serializes a client-originated message, without the trailing \r\n.
+// Invalid parameters (e.g. a non-final parameter containing a space) produce
+// the empty string.
+func makeLine(command string, params ...string) string {
+	return makeLineWithSource("", command, params...)
+}
+
+func makeLineWithSource(source, command string, params ...string) string {
+	msg := ircmsg.MakeMessage(nil, source, command, params...)
+	line, _ := msg.Line()
+	return strings.TrimSuffix(line, "\r\n")
+}
@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func feedLine(t *testing.T, r *Registrar, line string) []string {
+	t.Helper()
+	msg, err := ircmsg.ParseLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err := r.HandleMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func assertLines(t *testing.T, actual []string, expected ...string) {
+	t.Helper()
+	if len(actual) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestRegistrationNickFallback(t *testing.T) {
+	r := NewRegistrar(RegistrationConfig{Nick: "dog", Password: "hunter2"})
+	assertLines(t, r.Start(), "PASS hunter2", "NICK dog", "USER dog 0 * ircdog")
+	assertLines(t, feedLine(t, r, ":irc.example.com 433 * dog :Nickname is already in use"), "NICK dog_")
+	assertLines(t, feedLine(t, r, ":irc.example.com 432 * dog_ :Erroneous nickname"), "NICK dog__")
+	assertLines(t, feedLine(t, r, ":irc.example.com 001 dog__ :Welcome"))
+	if !r.Registered() || r.Nick() != "dog__" {
+		t.Errorf("expected to be registered as dog__")
+	}
+	// no more fallbacks after registration
+	assertLines(t, feedLine(t, r, ":irc.example.com 433 dog__ cat :Nickname is already in use"))
+
+	r = NewRegistrar(RegistrationConfig{Nick: "dog", User: "u", Realname: "Real Name", NickFallback: NickFallbackDigits})
+	assertLines(t, r.Start(), "NICK dog", "USER u 0 * :Real Name")
+	assertLines(t, feedLine(t, r, ":irc.example.com 433 * dog :Nickname is already in use"), "NICK dog1")
+	assertLines(t, feedLine(t, r, ":irc.example.com 436 * dog1 :Nickname collision"), "NICK dog2")
+
+	r = NewRegistrar(RegistrationConfig{Nick: "dog", NickFallback: NickFallbackNone})
+	r.Start()
+	assertLines(t, feedLine(t, r, ":irc.example.com 433 * dog :Nickname is already in use"))
+}
+
+func TestRegistrationCapNegotiation(t *testing.T) {
+	r := NewRegistrar(RegistrationConfig{Nick: "dog", Caps: []string{"sasl", "message-tags", "draft/nonexistent"}})
+	assertLines(t, r.Start(), "CAP LS 302", "NICK dog", "USER dog 0 * ircdog")
+	assertLines(t, feedLine(t, r, ":irc.example.com CAP * LS * :multi-prefix sasl=PLAIN,EXTERNAL"))
+	assertLines(t, feedLine(t, r, ":irc.example.com CAP * LS :message-tags server-time"), "CAP REQ :sasl message-tags")
+	if r.ServerCaps()["sasl"] != "PLAIN,EXTERNAL" || len(r.ServerCaps()) != 4 {
+		t.Errorf("bad server caps: %#v", r.ServerCaps())
+	}
+	assertLines(t, feedLine(t, r, ":irc.example.com CAP * ACK :sasl message-tags"), "CAP END")
+	if !(r.CapEnabled("sasl") && r.CapEnabled("message-tags")) {
+		t.Errorf("caps were not enabled")
+	}
+	assertLines(t, feedLine(t, r, ":irc.example.com 001 dog :Welcome"))
+
+	// cap-notify: re-request caps that come back
+	assertLines(t, feedLine(t, r, ":irc.example.com CAP dog DEL :sasl"))
+	if r.CapEnabled("sasl") {
+		t.Errorf("sasl should have been disabled by CAP DEL")
+	}
+	assertLines(t, feedLine(t, r, ":irc.example.com CAP dog NEW :sasl=PLAIN draft/nonexistent"), "CAP REQ :sasl draft/nonexistent")
+	assertLines(t, feedLine(t, r, ":irc.example.com CAP dog NAK :sasl draft/nonexistent"))
+
+	// nothing to request: end negotiation right away
+	r = NewRegistrar(RegistrationConfig{Caps: []string{"draft/nonexistent"}})
+	assertLines(t, r.Start(), "CAP LS 302")
+	assertLines(t, feedLine(t, r, ":irc.example.com CAP * LS :message-tags"), "CAP END")
+}
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	// how strictly lines from the client are compared to the transcript:
+	ReplayMatchExact   = "exact"
+	ReplayMatchCommand = "command"
+	// any line counts as a match, so playback is paced by the number of lines
+	ReplayMatchIgnore = "ignore"
+)
+
+// ReplaySession plays back the server side of a transcript to a single
+// client: before sending each group of server (<-) lines, it waits for the
+// client (->) lines that preceded them in the transcript. If the transcript
+// contains multiple connections, only the first is played back. Lines that
+// ircdog injected into the server side in proxy mode (=>) are skipped, since
+// the client didn't send them, but lines injected to the client (<=) are
+// played back, since it received them. Where a proxy rewrote or dropped
+// lines, the client's side is matched against what it originally sent (->),
+// and the server's side is played back as the client received it: the
+// replacement lines (<~) in place of the original, and nothing for a dropped
+// line (<x). Like Registrar, it does no I/O of its own.
+type ReplaySession struct {
+	entries []TranscriptEntry
+	match   string
+	pos     int
+}
+
+func NewReplaySession(entries []TranscriptEntry, match string) *ReplaySession {
+	var connection []TranscriptEntry
+	for _, entry := range entries {
+		if entry.ConnectionID == entries[0].ConnectionID {
+			connection = append(connection, entry)
+		}
+	}
+	var filtered []TranscriptEntry
+	for i, entry := range connection {
+		switch {
+		case entry.Rewritten || entry.Dropped:
+			// handled along with the received line
+		case entry.IsClient:
+			if !entry.Injected {
+				filtered = append(filtered, entry)
+			}
+		case entry.Injected:
+			filtered = append(filtered, entry)
+		default:
+			filtered = append(filtered, receivedServerLines(entry, connection[i+1:])...)
+		}
+	}
+	return &ReplaySession{
+		entries: filtered,
+		match:   match,
+	}
+}
+
+// receivedServerLines returns what the client received in place of a server
+// line, given the entries after it: the rewritten lines or nothing if the
+// proxy changed or dropped it, otherwise the line itself.
+func receivedServerLines(entry TranscriptEntry, following []TranscriptEntry) (result []TranscriptEntry) {
+	for _, next := range following {
+		// lines in the other direction, or injected lines, may be interleaved
+		if next.IsClient || next.Injected {
+			continue
+		}
+		if next.Dropped {
+			return nil
+		} else if !next.Rewritten {
+			break
+		}
+		result = append(result, next)
+	}
+	if result == nil {
+		result = []TranscriptEntry{entry}
+	}
+	return result
+}
+
+// ServerLines returns the server lines that should now be sent: that is,
+// all consecutive server lines at the current position in the transcript.
+func (r *ReplaySession) ServerLines() (lines []string) {
+	for r.pos < len(r.entries) && !r.entries[r.pos].IsClient {
+		lines = append(lines, r.entries[r.pos].Line)
+		r.pos++
+	}
+	return
+}
+
+// HandleClientLine consumes a line from the client. If it doesn't match
+// the transcript, it returns a description of the mismatch as a diff.
+func (r *ReplaySession) HandleClientLine(line string) (mismatch string) {
+	if r.Done() {
+		return fmt.Sprintf("transcript ended, but received:\n+ %s", line)
+	}
+	expected := r.entries[r.pos]
+	r.pos++
+	if !r.matches(expected.Line, line) {
+		return fmt.Sprintf("mismatch at transcript line %d:\n- %s\n+ %s", expected.LineNum, expected.Line, line)
+	}
+	return ""
+}
+
+// Done returns whether the entire transcript has been played back.
+func (r *ReplaySession) Done() bool {
+	return r.pos >= len(r.entries)
+}
+
+func (r *ReplaySession) matches(expected, actual string) bool {
+	switch r.match {
+	case ReplayMatchIgnore:
+		return true
+	case ReplayMatchCommand:
+		expectedMsg, expectedErr := ircmsg.ParseLine(expected)
+		actualMsg, actualErr := ircmsg.ParseLine(actual)
+		if expectedErr != nil || actualErr != nil {
+			return expected == actual
+		}
+		return strings.EqualFold(expectedMsg.Command, actualMsg.Command)
+	default:
+		return expected == actual
+	}
+}
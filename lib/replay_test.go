@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadTranscript(t *testing.T) {
+	transcriptFile := filepath.Join(t.TempDir(), "transcript")
+	err := os.WriteFile(transcriptFile, []byte("-> NICK dog\r\n\n2024-09-15T12:00:00.123456Z <- :irc.test 001 dog :hi\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadTranscript(transcriptFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %#v", entries)
+	}
+	if !entries[0].IsClient || entries[0].Line != "NICK dog" || !entries[0].Time.IsZero() || entries[0].LineNum != 1 {
+		t.Errorf("bad entry: %#v", entries[0])
+	}
+	if entries[1].IsClient || entries[1].Line != ":irc.test 001 dog :hi" || entries[1].Time.Nanosecond() != 123456000 || entries[1].LineNum != 3 {
+		t.Errorf("bad entry: %#v", entries[1])
+	}
+
+	os.WriteFile(transcriptFile, []byte("[2] -> NICK dog\n2024-09-15T12:00:00.123456Z [3] <- PING x\n"), 0600)
+	entries, err = ReadTranscript(transcriptFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ConnectionID != 2 || !entries[0].IsClient || entries[1].ConnectionID != 3 || entries[1].Line != "PING x" {
+		t.Errorf("bad entries: %#v", entries)
+	}
+	r := NewReplaySession(entries, ReplayMatchExact)
+	if r.HandleClientLine("NICK dog") != "" || !r.Done() {
+		t.Errorf("expected only the first connection to be replayed")
+	}
+
+	os.WriteFile(transcriptFile, []byte("=> MODE #chan +o dog\n<= :irc.test NOTICE * :hi\n"), 0600)
+	entries, err = ReadTranscript(transcriptFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !entries[0].IsClient || !entries[0].Injected || entries[1].IsClient || !entries[1].Injected {
+		t.Errorf("bad injected entries: %#v", entries)
+	}
+
+	os.WriteFile(transcriptFile, []byte("NICK dog\n"), 0600)
+	if _, err := ReadTranscript(transcriptFile); err == nil {
+		t.Errorf("accepted line without a direction")
+	}
+}
+
+func testReplayEntries() []TranscriptEntry {
+	return []TranscriptEntry{
+		{LineNum: 1, IsClient: false, Line: "NOTICE * :hello"},
+		{LineNum: 2, IsClient: true, Line: "NICK dog"},
+		{LineNum: 3, IsClient: true, Line: "USER u 0 * :r"},
+		{LineNum: 4, IsClient: false, Line: ":irc.test 001 dog :hi"},
+		{LineNum: 5, IsClient: false, Line: ":irc.test 422 dog :no motd"},
+	}
+}
+
+func TestReplaySession(t *testing.T) {
+	r := NewReplaySession(testReplayEntries(), ReplayMatchExact)
+	assertLines(t, r.ServerLines(), "NOTICE * :hello")
+	assertLines(t, r.ServerLines())
+	if mismatch := r.HandleClientLine("NICK dog"); mismatch != "" {
+		t.Errorf("unexpected mismatch: %s", mismatch)
+	}
+	assertLines(t, r.ServerLines())
+	if mismatch := r.HandleClientLine("USER x 0 * :r"); mismatch != "mismatch at transcript line 3:\n- USER u 0 * :r\n+ USER x 0 * :r" {
+		t.Errorf("bad mismatch: %s", mismatch)
+	}
+	assertLines(t, r.ServerLines(), ":irc.test 001 dog :hi", ":irc.test 422 dog :no motd")
+	if !r.Done() {
+		t.Errorf("expected replay to be done")
+	}
+	if mismatch := r.HandleClientLine("QUIT"); !strings.HasPrefix(mismatch, "transcript ended") {
+		t.Errorf("bad mismatch: %s", mismatch)
+	}
+}
+
+func TestReplaySessionInjected(t *testing.T) {
+	r := NewReplaySession([]TranscriptEntry{
+		{LineNum: 1, IsClient: true, Injected: true, Line: "WEBIRC pass ircdog localhost 127.0.0.1"},
+		{LineNum: 2, IsClient: true, Line: "NICK dog"},
+		{LineNum: 3, IsClient: false, Injected: true, Line: ":irc.test NOTICE dog :injected"},
+		{LineNum: 4, IsClient: false, Line: ":irc.test 001 dog :hi"},
+	}, ReplayMatchExact)
+	assertLines(t, r.ServerLines())
+	if mismatch := r.HandleClientLine("NICK dog"); mismatch != "" {
+		t.Errorf("unexpected mismatch: %s", mismatch)
+	}
+	// the client received the injected line, so it is played back
+	assertLines(t, r.ServerLines(), ":irc.test NOTICE dog :injected", ":irc.test 001 dog :hi")
+	if !r.Done() {
+		t.Errorf("expected replay to be done")
+	}
+}
+
+func TestReplaySessionRewritten(t *testing.T) {
+	transcriptFile := filepath.Join(t.TempDir(), "transcript")
+	err := os.WriteFile(transcriptFile, []byte(strings.Join([]string{
+		"-> PRIVMSG #chan :colour",
+		"~> PRIVMSG #chan :color",
+		"-> PRIVMSG #chan :secret",
+		"x> PRIVMSG #chan :secret",
+		"<- :irc.test NOTICE dog :colour",
+		// a line in the other direction can be recorded in between
+		"-> PING x",
+		"<~ :irc.test NOTICE dog :color",
+		"<~ :irc.test NOTICE dog :color again",
+		"<- :irc.test NOTICE dog :dropped",
+		"<x :irc.test NOTICE dog :dropped",
+		"<- :irc.test NOTICE dog :unchanged",
+		"",
+	}, "\r\n")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadTranscript(transcriptFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entries[3].Dropped || !entries[3].IsClient || !entries[9].Dropped || entries[9].IsClient {
+		t.Errorf("bad dropped entries: %#v", entries)
+	}
+
+	r := NewReplaySession(entries, ReplayMatchExact)
+	assertLines(t, r.ServerLines())
+	// the client is matched against what it sent, not what the proxy sent
+	for _, line := range []string{"PRIVMSG #chan :colour", "PRIVMSG #chan :secret"} {
+		if mismatch := r.HandleClientLine(line); mismatch != "" {
+			t.Errorf("unexpected mismatch: %s", mismatch)
+		}
+	}
+	// the client receives what the proxy sent it
+	assertLines(t, r.ServerLines(), ":irc.test NOTICE dog :color", ":irc.test NOTICE dog :color again")
+	if mismatch := r.HandleClientLine("PING x"); mismatch != "" {
+		t.Errorf("unexpected mismatch: %s", mismatch)
+	}
+	assertLines(t, r.ServerLines(), ":irc.test NOTICE dog :unchanged")
+	if !r.Done() {
+		t.Errorf("expected replay to be done")
+	}
+}
+
+func TestReplaySessionMatching(t *testing.T) {
+	r := NewReplaySession(testReplayEntries(), ReplayMatchCommand)
+	r.ServerLines()
+	if mismatch := r.HandleClientLine("nick cat"); mismatch != "" {
+		t.Errorf("unexpected mismatch: %s", mismatch)
+	}
+	if mismatch := r.HandleClientLine("PASS x"); mismatch == "" {
+		t.Errorf("expected mismatch")
+	}
+
+	r = NewReplaySession(testReplayEntries(), ReplayMatchIgnore)
+	r.ServerLines()
+	if mismatch := r.HandleClientLine("PASS x"); mismatch != "" {
+		t.Errorf("unexpected mismatch: %s", mismatch)
+	}
+}
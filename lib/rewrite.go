@@ -0,0 +1,222 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Rewrite rules files for the proxy contain rules, each of which is a
+// LinePattern on its own line, followed by indented actions:
+//
+//	> PRIVMSG /^PRIVMSG (\S+) :(?P<text>.*)/
+//		!sub |colour|color|
+//		!tag +example/tag=1
+//		!dup
+//
+//	< 005
+//		!drop
+//
+// Only the first matching rule is applied. Its actions run in order:
+// `!sub |<regex>|<replacement>|` (any delimiter may be used, and the
+// replacement may refer to groups as $1), `!drop`, `!dup [<count>]`,
+// `!tag <name>[=<value>]`, `!untag <name>` (or * for all tags), and lines,
+// which replace the original line, and in which ${0} (the entire line),
+// numbered and named groups from the rule's regex are substituted.
+
+// RewriteRule is a rule from a rewrite rules file.
+type RewriteRule struct {
+	LinePattern
+	// line number within the rules file, for display
+	LineNum int
+	Actions []RewriteAction
+}
+
+// RewriteAction is a step in rewriting a line; exactly one field is set.
+type RewriteAction struct {
+	// a line to send instead of the original; this is a template
+	Line        string
+	Sub         *regexp.Regexp
+	Replacement string
+	Drop        bool
+	// number of additional copies of the line to send
+	Dup       int
+	SetTag    string
+	TagValue  string
+	DeleteTag string
+}
+
+// ReadRewriteRules reads and parses a rewrite rules file.
+func ReadRewriteRules(filename string) (rules []RewriteRule, err error) {
+	infile, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer infile.Close()
+	scanner := bufio.NewScanner(infile)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			pattern, err := ParseLinePattern(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			rules = append(rules, RewriteRule{LinePattern: pattern, LineNum: lineNum})
+			continue
+		}
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("line %d: action outside of a rule", lineNum)
+		}
+		action, err := parseRewriteAction(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		rule := &rules[len(rules)-1]
+		rule.Actions = append(rule.Actions, action)
+	}
+	return rules, scanner.Err()
+}
+
+func parseRewriteAction(action string) (result RewriteAction, err error) {
+	if !strings.HasPrefix(action, "!") {
+		return RewriteAction{Line: action}, nil
+	}
+	directive, rest, _ := strings.Cut(action[1:], " ")
+	rest = strings.TrimSpace(rest)
+	switch strings.ToLower(directive) {
+	case "sub":
+		if len(rest) < 3 {
+			return result, fmt.Errorf("expected !sub |<regex>|<replacement>|")
+		}
+		delimiter := rest[:1]
+		parts := strings.Split(rest[1:], delimiter)
+		if len(parts) != 3 || parts[2] != "" {
+			return result, fmt.Errorf("expected !sub %s<regex>%s<replacement>%s", delimiter, delimiter, delimiter)
+		}
+		result.Sub, err = regexp.Compile(parts[0])
+		result.Replacement = parts[1]
+	case "drop":
+		result.Drop = true
+	case "dup":
+		result.Dup = 1
+		if rest != "" {
+			result.Dup, err = strconv.Atoi(rest)
+			if err == nil && result.Dup < 1 {
+				err = fmt.Errorf("invalid count %d", result.Dup)
+			}
+		}
+	case "tag":
+		if rest == "" {
+			return result, fmt.Errorf("expected !tag <name>[=<value>]")
+		}
+		result.SetTag, result.TagValue, _ = strings.Cut(rest, "=")
+	case "untag":
+		if rest == "" {
+			return result, fmt.Errorf("expected !untag <name>")
+		}
+		result.DeleteTag = rest
+	default:
+		err = fmt.Errorf("unknown directive `%s`", directive)
+	}
+	return
+}
+
+// RewriteLine applies the first matching rule to a line, returning the lines
+// that should be sent in its place (possibly none). If no rule matched,
+// rule is nil and the line should be sent unchanged.
+func RewriteLine(rules []RewriteRule, line string, toServer bool) (lines []string, rule *RewriteRule) {
+	var submatches []string
+	for i := range rules {
+		if submatches = rules[i].Match(line, toServer); submatches != nil {
+			rule = &rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		return []string{line}, nil
+	}
+
+	vars := map[string]string{"0": line}
+	if rule.Regex != nil {
+		vars = regexVariables(rule.Regex, submatches)
+	}
+	lines = []string{line}
+	replaced := false
+	for _, action := range rule.Actions {
+		switch {
+		case action.Line != "":
+			if !replaced {
+				lines, replaced = nil, true
+			}
+			lines = append(lines, expandTemplate(action.Line, vars))
+		case action.Sub != nil:
+			for i := range lines {
+				lines[i] = action.Sub.ReplaceAllString(lines[i], action.Replacement)
+			}
+		case action.Drop:
+			lines, replaced = nil, true
+		case action.Dup != 0:
+			duplicated := make([]string, 0, len(lines)*(action.Dup+1))
+			for _, line := range lines {
+				for i := 0; i <= action.Dup; i++ {
+					duplicated = append(duplicated, line)
+				}
+			}
+			lines = duplicated
+		case action.SetTag != "" || action.DeleteTag != "":
+			for i := range lines {
+				lines[i] = rewriteTags(lines[i], &action)
+			}
+		}
+	}
+	return lines, rule
+}
+
+func rewriteTags(line string, action *RewriteAction) string {
+	msg, err := ircmsg.ParseLine(line)
+	if err != nil {
+		return line
+	}
+	if action.SetTag != "" {
+		msg.SetTag(action.SetTag, action.TagValue)
+	} else if action.DeleteTag == "*" {
+		for name := range msg.AllTags() {
+			msg.DeleteTag(name)
+		}
+	} else {
+		msg.DeleteTag(action.DeleteTag)
+	}
+	if hasTrailing(line) {
+		// preserve the original's formatting as far as possible
+		msg.ForceTrailing()
+	}
+	result, err := msg.Line()
+	if err != nil {
+		return line
+	}
+	return strings.TrimSuffix(result, "\r\n")
+}
+
+// hasTrailing returns whether a line's final parameter is a trailing one
+// (i.e., prefixed with a colon).
+func hasTrailing(line string) bool {
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+		line = strings.TrimLeft(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	return strings.Contains(line, " :")
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readTestRewriteRules(t *testing.T, contents string) []RewriteRule {
+	t.Helper()
+	rulesFile := filepath.Join(t.TempDir(), "rules")
+	if err := os.WriteFile(rulesFile, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := ReadRewriteRules(rulesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestRewriteLine(t *testing.T) {
+	rules := readTestRewriteRules(t, `# comment
+> PRIVMSG /^PRIVMSG (\S+) :(?P<text>.*)/
+	!sub |colour|color|
+	!tag +example/tag=1
+	!dup
+
+< 005
+	!drop
+
+<> * /^PING (.*)/
+	PING :rewritten ${1}
+	NOTICE * :was ${0}
+
+< *
+	!untag *
+`)
+	lines, rule := RewriteLine(rules, "PRIVMSG #chan :nice colour", true)
+	if rule == nil || rule.LineNum != 2 {
+		t.Fatalf("expected rule at line 2 to match, got %#v", rule)
+	}
+	assertLines(t, lines, "@+example/tag=1 PRIVMSG #chan :nice color", "@+example/tag=1 PRIVMSG #chan :nice color")
+
+	// direction must match
+	lines, rule = RewriteLine(rules, "PRIVMSG #chan :nice colour", false)
+	if rule == nil || rule.LineNum != 14 {
+		t.Fatalf("expected rule at line 14 to match, got %#v", rule)
+	}
+	assertLines(t, lines, "PRIVMSG #chan :nice colour")
+
+	lines, _ = RewriteLine(rules, ":irc.test 005 dog CHANTYPES=# :are supported", false)
+	assertLines(t, lines)
+
+	lines, _ = RewriteLine(rules, "PING x", false)
+	assertLines(t, lines, "PING :rewritten x", "NOTICE * :was PING x")
+
+	lines, _ = RewriteLine(rules, "@time=x;+a=b :irc.test NOTICE * :hi", false)
+	assertLines(t, lines, ":irc.test NOTICE * :hi")
+
+	lines, rule = RewriteLine(rules, "NICK dog", true)
+	if rule != nil {
+		t.Errorf("expected no rule to match, got %#v", rule)
+	}
+	assertLines(t, lines, "NICK dog")
+}
+
+func TestReadRewriteRulesErrors(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules")
+	for _, contents := range []string{
+		"PRIVMSG\n",
+		"> PRIVMSG /unterminated\n",
+		"\t!drop\n",
+		"> *\n\t!sub |a|b\n",
+		"> *\n\t!dup 0\n",
+		"> *\n\t!explode\n",
+	} {
+		os.WriteFile(rulesFile, []byte(contents), 0600)
+		if _, err := ReadRewriteRules(rulesFile); err == nil {
+			t.Errorf("accepted invalid rules: %q", contents)
+		}
+	}
+}
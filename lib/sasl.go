@@ -0,0 +1,221 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+const (
+	SASLPlain       = "PLAIN"
+	SASLExternal    = "EXTERNAL"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+
+	// AUTHENTICATE payloads are base64-encoded and split into chunks of this size
+	saslChunkSize = 400
+)
+
+var (
+	errSCRAMBadServerSignature = errors.New("server signature did not verify; the server may not know your password")
+	errSCRAMUnexpectedMessage  = errors.New("unexpected message from server")
+)
+
+// saslMechanism is the client side of a SASL exchange.
+type saslMechanism interface {
+	// Next takes a (decoded) challenge from the server and returns our response.
+	// The first call receives the server's empty initial challenge.
+	Next(challenge []byte) (response []byte, err error)
+}
+
+func newSASLMechanism(mechanism, username, password string) (saslMechanism, error) {
+	switch mechanism {
+	case SASLPlain:
+		return &saslPlain{username: username, password: password}, nil
+	case SASLExternal:
+		return &saslExternal{authzid: username}, nil
+	case SASLScramSHA256:
+		return newSCRAM(sha256.New, username, password), nil
+	case SASLScramSHA512:
+		return newSCRAM(sha512.New, username, password), nil
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism %s", mechanism)
+	}
+}
+
+type saslPlain struct {
+	username string
+	password string
+}
+
+func (s *saslPlain) Next(challenge []byte) ([]byte, error) {
+	// authzid \0 authcid \0 password; we leave authzid empty
+	return []byte("\x00" + s.username + "\x00" + s.password), nil
+}
+
+type saslExternal struct {
+	authzid string
+}
+
+func (s *saslExternal) Next(challenge []byte) ([]byte, error) {
+	return []byte(s.authzid), nil
+}
+
+// scramClient implements the client side of RFC 5802 (SCRAM), without
+// channel binding or SASLprep of the password.
+type scramClient struct {
+	newHash  func() hash.Hash
+	username string
+	password string
+
+	step            int
+	clientNonce     string
+	clientFirstBare string
+	serverSignature []byte
+}
+
+func newSCRAM(newHash func() hash.Hash, username, password string) *scramClient {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return &scramClient{
+		newHash:     newHash,
+		username:    username,
+		password:    password,
+		clientNonce: base64.RawStdEncoding.EncodeToString(nonce),
+	}
+}
+
+func (s *scramClient) Next(challenge []byte) (response []byte, err error) {
+	s.step++
+	switch s.step {
+	case 1:
+		return s.clientFirst(), nil
+	case 2:
+		return s.clientFinal(string(challenge))
+	case 3:
+		return nil, s.verifyServerFinal(string(challenge))
+	default:
+		return nil, errSCRAMUnexpectedMessage
+	}
+}
+
+func (s *scramClient) clientFirst() []byte {
+	username := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(s.username)
+	s.clientFirstBare = "n=" + username + ",r=" + s.clientNonce
+	return []byte("n,," + s.clientFirstBare)
+}
+
+func (s *scramClient) clientFinal(serverFirst string) (response []byte, err error) {
+	attrs := parseSCRAMAttributes(serverFirst)
+	if errAttr, ok := attrs["e"]; ok {
+		return nil, fmt.Errorf("server reported error: %s", errAttr)
+	}
+	nonce := attrs["r"]
+	if !strings.HasPrefix(nonce, s.clientNonce) || len(nonce) == len(s.clientNonce) {
+		return nil, errors.New("server sent an invalid nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		return nil, errors.New("server sent an invalid salt")
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations < 1 {
+		return nil, errors.New("server sent an invalid iteration count")
+	}
+
+	saltedPassword := pbkdf2([]byte(s.password), salt, iterations, s.newHash().Size(), s.newHash)
+	clientKey := s.hmac(saltedPassword, []byte("Client Key"))
+	h := s.newHash()
+	h.Write(clientKey)
+	storedKey := h.Sum(nil)
+
+	clientFinalWithoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte("n,,")) + ",r=" + nonce
+	authMessage := []byte(s.clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof)
+	clientSignature := s.hmac(storedKey, authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+	serverKey := s.hmac(saltedPassword, []byte("Server Key"))
+	s.serverSignature = s.hmac(serverKey, authMessage)
+
+	return []byte(clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+func (s *scramClient) verifyServerFinal(serverFinal string) error {
+	attrs := parseSCRAMAttributes(serverFinal)
+	if errAttr, ok := attrs["e"]; ok {
+		return fmt.Errorf("server reported error: %s", errAttr)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || !hmac.Equal(signature, s.serverSignature) {
+		return errSCRAMBadServerSignature
+	}
+	return nil
+}
+
+func (s *scramClient) hmac(key, message []byte) []byte {
+	mac := hmac.New(s.newHash, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func parseSCRAMAttributes(message string) map[string]string {
+	result := make(map[string]string)
+	for _, attr := range strings.Split(message, ",") {
+		if key, value, found := strings.Cut(attr, "="); found {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// pbkdf2 is PBKDF2 from RFC 8018, with HMAC as the PRF
+func pbkdf2(password, salt []byte, iterations, keyLen int, newHash func() hash.Hash) []byte {
+	prf := hmac.New(newHash, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var result []byte
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u = prf.Sum(u[:0])
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		result = append(result, t...)
+	}
+	return result[:keyLen]
+}
+
+// encodeAuthenticate produces the AUTHENTICATE lines for a SASL response.
+func encodeAuthenticate(response []byte) (lines []string) {
+	encoded := base64.StdEncoding.EncodeToString(response)
+	for len(encoded) >= saslChunkSize {
+		lines = append(lines, "AUTHENTICATE "+encoded[:saslChunkSize])
+		encoded = encoded[saslChunkSize:]
+	}
+	// a final chunk of exactly 400 bytes (or an empty response) must be followed by +
+	if encoded == "" {
+		encoded = "+"
+	}
+	return append(lines, "AUTHENTICATE "+encoded)
+}
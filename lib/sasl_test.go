@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func TestSCRAMSHA256(t *testing.T) {
+	// test vector from RFC 7677
+	client := newSCRAM(sha256.New, "user", "pencil")
+	client.clientNonce = "rOprNGfwEbeRWgbNEkqO"
+	clientFirst, err := client.Next(nil)
+	if err != nil || string(clientFirst) != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Fatalf("bad client-first-message %s (%v)", clientFirst, err)
+	}
+	clientFinal, err := client.Next([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))
+	if err != nil || string(clientFinal) != "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=" {
+		t.Fatalf("bad client-final-message %s (%v)", clientFinal, err)
+	}
+	if _, err := client.Next([]byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")); err != nil {
+		t.Errorf("valid server signature was rejected: %v", err)
+	}
+
+	client = newSCRAM(sha256.New, "user", "pencil")
+	client.clientNonce = "rOprNGfwEbeRWgbNEkqO"
+	client.Next(nil)
+	client.Next([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))
+	if _, err := client.Next([]byte("v=AAAATRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")); err != errSCRAMBadServerSignature {
+		t.Errorf("invalid server signature was accepted")
+	}
+}
+
+func TestEncodeAuthenticate(t *testing.T) {
+	assertLines(t, encodeAuthenticate(nil), "AUTHENTICATE +")
+	assertLines(t, encodeAuthenticate([]byte("\x00dog\x00hunter2")), "AUTHENTICATE AGRvZwBodW50ZXIy")
+	// 300 bytes encode to exactly 400 bytes of base64:
+	exact := encodeAuthenticate([]byte(strings.Repeat("a", 300)))
+	if len(exact) != 2 || len(exact[0]) != len("AUTHENTICATE ")+400 || exact[1] != "AUTHENTICATE +" {
+		t.Errorf("bad chunking: %#v", exact)
+	}
+	long := encodeAuthenticate([]byte(strings.Repeat("a", 400)))
+	if len(long) != 2 || long[1] != "AUTHENTICATE "+base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 400)))[400:] {
+		t.Errorf("bad chunking: %#v", long)
+	}
+}
+
+func TestRegistrationSASL(t *testing.T) {
+	r := NewRegistrar(RegistrationConfig{Nick: "dog", SASLMechanism: SASLPlain, SASLUsername: "dog", SASLPassword: "hunter2"})
+	assertLines(t, r.Start(), "CAP LS 302", "NICK dog", "USER dog 0 * ircdog")
+	assertLines(t, feedLine(t, r, ":irc.example.com CAP * LS :sasl=PLAIN,EXTERNAL"), "CAP REQ sasl")
+	assertLines(t, feedLine(t, r, ":irc.example.com CAP * ACK :sasl"), "AUTHENTICATE PLAIN")
+	assertLines(t, feedLine(t, r, "AUTHENTICATE +"), "AUTHENTICATE AGRvZwBodW50ZXIy")
+	assertLines(t, feedLine(t, r, ":irc.example.com 900 dog dog!dog@localhost dog :You are now logged in as dog"))
+	assertLines(t, feedLine(t, r, ":irc.example.com 903 dog :SASL authentication successful"), "CAP END")
+
+	// failure, not required: continue registration
+	r = NewRegistrar(RegistrationConfig{Nick: "dog", SASLMechanism: SASLPlain, SASLUsername: "dog", SASLPassword: "hunter3"})
+	r.Start()
+	feedLine(t, r, ":irc.example.com CAP * LS :sasl")
+	feedLine(t, r, ":irc.example.com CAP * ACK :sasl")
+	feedLine(t, r, "AUTHENTICATE +")
+	assertLines(t, feedLine(t, r, ":irc.example.com 904 dog :SASL authentication failed"), "CAP END")
+
+	// failure, required: fatal error
+	r = NewRegistrar(RegistrationConfig{Nick: "dog", SASLMechanism: SASLPlain, SASLUsername: "dog", SASLPassword: "hunter3", SASLRequired: true})
+	r.Start()
+	feedLine(t, r, ":irc.example.com CAP * LS :sasl")
+	feedLine(t, r, ":irc.example.com CAP * ACK :sasl")
+	feedLine(t, r, "AUTHENTICATE +")
+	msg, _ := ircmsg.ParseLine(":irc.example.com 904 dog :SASL authentication failed")
+	if _, err := r.HandleMessage(msg); err != ErrSASLFailed {
+		t.Errorf("expected ErrSASLFailed, got %v", err)
+	}
+}
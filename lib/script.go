@@ -0,0 +1,488 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Scripts are lists of IRC lines to send, interspersed with directives,
+// which are lines beginning with `!`:
+//
+//	!expect <pattern> [<timeout>]                 wait for a matching message
+//	!expect-not <pattern> <duration>              fail if a matching message arrives
+//	!capture <var> <param> <pattern> [<timeout>]  wait, then save a parameter
+//	!sleep <duration>                             pause before continuing
+//	!timeout <duration>                           set the default timeout
+//	!exit [<status>]                              disconnect and exit
+//
+// A pattern is either a command or numeric, like PRIVMSG or 001, or a
+// regular expression delimited by slashes, like /^:\S+ 001 (?P<nick>\S+)/,
+// which is matched against the raw line. Named groups in a regular expression
+// are saved as variables. <param> is a parameter index (0-based; negative
+// indices count from the end), or one of `source`, `nick` or `command`.
+// Variables are substituted into later lines and directives as ${name}.
+
+const (
+	DefaultScriptTimeout = 10 * time.Second
+
+	// how many recent lines to include in a failure report
+	scriptReportLines = 10
+)
+
+var (
+	errScriptDisconnected = errors.New("disconnected from server")
+	scriptVariableRegex   = regexp.MustCompile(`\$\{([A-Za-z0-9_-]+)\}`)
+	scriptVariableName    = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// ScriptStep is a single line of a script.
+type ScriptStep struct {
+	// the original text of the line
+	Text      string
+	Directive string // empty for lines to be sent as-is
+	Args      []string
+	// for directives that take a pattern, the unparsed pattern
+	// (it may contain variables, so it is compiled at runtime)
+	Pattern string
+}
+
+// ParseScript parses the lines returned by ReadScript, validating directives.
+func ParseScript(lines []string) (steps []ScriptStep, err error) {
+	for _, line := range lines {
+		step, err := parseScriptStep(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid script line `%s`: %w", line, err)
+		}
+		steps = append(steps, step)
+	}
+	return
+}
+
+func parseScriptStep(line string) (step ScriptStep, err error) {
+	step.Text = line
+	if !strings.HasPrefix(line, "!") {
+		return
+	}
+	directive, rest, _ := strings.Cut(line[1:], " ")
+	step.Directive = strings.ToLower(directive)
+	rest = strings.TrimSpace(rest)
+
+	// number of plain arguments before the pattern, if any,
+	// and the allowed number of arguments after it
+	var before, minAfter, maxAfter int
+	hasPattern := true
+	switch step.Directive {
+	case "expect":
+		minAfter, maxAfter = 0, 1
+	case "expect-not":
+		minAfter, maxAfter = 1, 1
+	case "capture":
+		before, minAfter, maxAfter = 2, 0, 1
+	case "sleep", "timeout":
+		hasPattern = false
+		minAfter, maxAfter = 1, 1
+	case "exit":
+		hasPattern = false
+		minAfter, maxAfter = 0, 1
+	default:
+		return step, fmt.Errorf("unknown directive `%s`", directive)
+	}
+
+	for i := 0; i < before; i++ {
+		var arg string
+		arg, rest, _ = strings.Cut(rest, " ")
+		if arg == "" {
+			return step, errors.New("missing arguments")
+		}
+		step.Args = append(step.Args, arg)
+		rest = strings.TrimSpace(rest)
+	}
+	if hasPattern {
+		if step.Pattern, rest, err = splitPattern(rest); err != nil {
+			return
+		}
+	}
+	after := strings.Fields(rest)
+	if len(after) < minAfter || len(after) > maxAfter {
+		return step, errors.New("wrong number of arguments")
+	}
+	step.Args = append(step.Args, after...)
+
+	// validate what we can without knowing the values of variables
+	switch step.Directive {
+	case "expect", "capture":
+		if len(after) != 0 {
+			_, err = parseScriptDuration(after[0])
+		}
+	case "expect-not", "sleep", "timeout":
+		_, err = parseScriptDuration(after[0])
+	case "exit":
+		if len(after) != 0 {
+			_, err = strconv.Atoi(after[0])
+		}
+	}
+	if err == nil && step.Directive == "capture" {
+		if !scriptVariableName.MatchString(step.Args[0]) {
+			err = fmt.Errorf("invalid variable name `%s`", step.Args[0])
+		} else {
+			_, err = parseParamSpec(step.Args[1])
+		}
+	}
+	return
+}
+
+// splitPattern splits a /regex/ (which may contain spaces and slashes)
+// or a single-word pattern from the start of the string
+func splitPattern(str string) (pattern, rest string, err error) {
+	if strings.HasPrefix(str, "/") {
+		end := strings.LastIndexByte(str, '/')
+		if end == 0 {
+			return "", "", errors.New("unterminated regular expression")
+		}
+		pattern, rest = str[:end+1], str[end+1:]
+		if rest != "" && rest[0] != ' ' {
+			return "", "", errors.New("unterminated regular expression")
+		}
+		if !strings.Contains(pattern, "${") {
+			if _, err = regexp.Compile(pattern[1 : len(pattern)-1]); err != nil {
+				return
+			}
+		}
+		return pattern, rest, nil
+	}
+	pattern, rest, _ = strings.Cut(str, " ")
+	if pattern == "" {
+		err = errors.New("missing pattern")
+	}
+	return
+}
+
+func parseScriptDuration(str string) (time.Duration, error) {
+	if intSeconds, err := strconv.Atoi(str); err == nil {
+		return time.Duration(intSeconds) * time.Second, nil
+	}
+	return time.ParseDuration(str)
+}
+
+func parseParamSpec(spec string) (index int, err error) {
+	switch spec {
+	case "source", "nick", "command":
+		return 0, nil
+	default:
+		index, err = strconv.Atoi(spec)
+		if err != nil {
+			err = fmt.Errorf("invalid parameter `%s`", spec)
+		}
+		return
+	}
+}
+
+// messagePattern is a compiled pattern
+type messagePattern struct {
+	command string
+	regex   *regexp.Regexp
+}
+
+func compilePattern(pattern string) (result messagePattern, err error) {
+	if strings.HasPrefix(pattern, "/") {
+		result.regex, err = regexp.Compile(pattern[1 : len(pattern)-1])
+	} else {
+		result.command = strings.ToUpper(pattern)
+	}
+	return
+}
+
+// match tests the pattern against the line, returning any named groups
+func (p *messagePattern) match(line string, msg ircmsg.Message, parseErr error) (matched bool, groups map[string]string) {
+	if p.regex == nil {
+		return parseErr == nil && msg.Command == p.command, nil
+	}
+	submatches := p.regex.FindStringSubmatch(line)
+	if submatches == nil {
+		return false, nil
+	}
+	groups = make(map[string]string)
+	for i, name := range p.regex.SubexpNames() {
+		if name != "" {
+			groups[name] = submatches[i]
+		}
+	}
+	return true, groups
+}
+
+// ScriptFailure is returned when a script's expectation was not met.
+type ScriptFailure struct {
+	Step   ScriptStep
+	Reason string
+	// the most recent lines received from the server
+	Recent []string
+}
+
+func (f *ScriptFailure) Error() string {
+	return fmt.Sprintf("script failed at `%s`: %s", f.Step.Text, f.Reason)
+}
+
+// Report returns a multi-line description of the failure.
+func (f *ScriptFailure) Report() string {
+	var buf strings.Builder
+	buf.WriteString(f.Error())
+	if len(f.Recent) != 0 {
+		buf.WriteString("\nmost recent lines received:")
+		for _, line := range f.Recent {
+			buf.WriteString("\n    ")
+			buf.WriteString(line)
+		}
+	}
+	return buf.String()
+}
+
+// ScriptRunner executes a script. It is driven by lines received from the
+// server, which must be passed to Deliver from any goroutine.
+type ScriptRunner struct {
+	steps []ScriptStep
+	send  func(string) error
+	vars  map[string]string
+
+	timeout time.Duration
+
+	mutex        sync.Mutex
+	pending      []string // received lines that have not been consumed
+	recent       []string
+	disconnected bool
+	finished     bool
+	notify       chan struct{}
+}
+
+// NewScriptRunner returns a runner for the script, which will send lines using send.
+func NewScriptRunner(steps []ScriptStep, send func(string) error) *ScriptRunner {
+	return &ScriptRunner{
+		steps:   steps,
+		send:    send,
+		vars:    make(map[string]string),
+		timeout: DefaultScriptTimeout,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// Deliver passes a line received from the server to the script.
+func (s *ScriptRunner) Deliver(line string) {
+	s.mutex.Lock()
+	if !s.finished {
+		s.pending = append(s.pending, line)
+		s.recent = append(s.recent, line)
+		if len(s.recent) > scriptReportLines {
+			s.recent = s.recent[1:]
+		}
+	}
+	s.mutex.Unlock()
+	s.wake()
+}
+
+// Disconnected informs the script that no more lines will be received.
+func (s *ScriptRunner) Disconnected() {
+	s.mutex.Lock()
+	s.disconnected = true
+	s.mutex.Unlock()
+	s.wake()
+}
+
+func (s *ScriptRunner) wake() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run executes the script. If the script ends with an !exit directive,
+// exited is true and status is the requested exit status. Failed
+// expectations are reported as a *ScriptFailure; other errors are
+// errors sending lines.
+func (s *ScriptRunner) Run() (status int, exited bool, err error) {
+	defer func() {
+		s.mutex.Lock()
+		s.finished = true
+		s.pending = nil
+		s.mutex.Unlock()
+	}()
+
+	for _, step := range s.steps {
+		var args []string
+		if step.Directive == "" {
+			line, subErr := s.substitute(step.Text, false)
+			if subErr != nil {
+				return 0, false, s.fail(step, subErr.Error())
+			}
+			if err = s.send(line); err != nil {
+				return
+			}
+			continue
+		}
+		for _, arg := range step.Args {
+			arg, subErr := s.substitute(arg, false)
+			if subErr != nil {
+				return 0, false, s.fail(step, subErr.Error())
+			}
+			args = append(args, arg)
+		}
+		// values substituted into a regular expression match literally
+		pattern, subErr := s.substitute(step.Pattern, strings.HasPrefix(step.Pattern, "/"))
+		if subErr != nil {
+			return 0, false, s.fail(step, subErr.Error())
+		}
+
+		switch step.Directive {
+		case "sleep":
+			duration, _ := parseScriptDuration(args[0])
+			time.Sleep(duration)
+		case "timeout":
+			s.timeout, _ = parseScriptDuration(args[0])
+		case "exit":
+			if len(args) != 0 {
+				status, _ = strconv.Atoi(args[0])
+			}
+			return status, true, nil
+		case "expect", "capture":
+			timeout := s.timeout
+			if step.Directive == "expect" && len(args) == 1 {
+				timeout, _ = parseScriptDuration(args[0])
+			} else if step.Directive == "capture" && len(args) == 3 {
+				timeout, _ = parseScriptDuration(args[2])
+			}
+			compiled, compileErr := compilePattern(pattern)
+			if compileErr != nil {
+				return 0, false, s.fail(step, compileErr.Error())
+			}
+			msg, failure := s.expect(compiled, timeout)
+			if failure != "" {
+				return 0, false, s.fail(step, failure)
+			}
+			if step.Directive == "capture" {
+				value, ok := getParam(msg, args[1])
+				if !ok {
+					return 0, false, s.fail(step, fmt.Sprintf("matching message has no parameter %s", args[1]))
+				}
+				s.vars[args[0]] = value
+			}
+		case "expect-not":
+			duration, _ := parseScriptDuration(args[0])
+			compiled, compileErr := compilePattern(pattern)
+			if compileErr != nil {
+				return 0, false, s.fail(step, compileErr.Error())
+			}
+			if failure := s.expectNot(compiled, duration); failure != "" {
+				return 0, false, s.fail(step, failure)
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+// expect waits for a line matching the pattern, consuming all lines up to
+// and including it; it returns a description of the failure, if any.
+func (s *ScriptRunner) expect(pattern messagePattern, timeout time.Duration) (result ircmsg.Message, failure string) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mutex.Lock()
+		for i, line := range s.pending {
+			msg, parseErr := ircmsg.ParseLine(line)
+			if matched, groups := pattern.match(line, msg, parseErr); matched {
+				s.pending = s.pending[i+1:]
+				s.mutex.Unlock()
+				for name, value := range groups {
+					s.vars[name] = value
+				}
+				return msg, ""
+			}
+		}
+		s.pending = s.pending[:0]
+		disconnected := s.disconnected
+		s.mutex.Unlock()
+		if disconnected {
+			return result, errScriptDisconnected.Error()
+		}
+
+		select {
+		case <-s.notify:
+		case <-timer.C:
+			return result, fmt.Sprintf("no matching message within %v", timeout)
+		}
+	}
+}
+
+// expectNot waits for the duration, failing if a matching line arrives;
+// it does not consume any lines.
+func (s *ScriptRunner) expectNot(pattern messagePattern, duration time.Duration) (failure string) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	checked := 0
+	for {
+		s.mutex.Lock()
+		for ; checked < len(s.pending); checked++ {
+			line := s.pending[checked]
+			msg, parseErr := ircmsg.ParseLine(line)
+			if matched, _ := pattern.match(line, msg, parseErr); matched {
+				s.mutex.Unlock()
+				return fmt.Sprintf("received unexpected message `%s`", line)
+			}
+		}
+		s.mutex.Unlock()
+
+		select {
+		case <-s.notify:
+		case <-timer.C:
+			return ""
+		}
+	}
+}
+
+func (s *ScriptRunner) fail(step ScriptStep, reason string) *ScriptFailure {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return &ScriptFailure{
+		Step:   step,
+		Reason: reason,
+		Recent: append([]string(nil), s.recent...),
+	}
+}
+
+func (s *ScriptRunner) substitute(str string, quoteRegex bool) (result string, err error) {
+	result = scriptVariableRegex.ReplaceAllStringFunc(str, func(match string) string {
+		name := match[2 : len(match)-1]
+		value, ok := s.vars[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("undefined variable `%s`", name)
+		}
+		if quoteRegex {
+			value = regexp.QuoteMeta(value)
+		}
+		return value
+	})
+	return
+}
+
+func getParam(msg ircmsg.Message, spec string) (value string, ok bool) {
+	switch spec {
+	case "source":
+		return msg.Source, true
+	case "nick":
+		return msg.Nick(), true
+	case "command":
+		return msg.Command, true
+	}
+	index, _ := strconv.Atoi(spec)
+	if index < 0 {
+		index += len(msg.Params)
+	}
+	if 0 <= index && index < len(msg.Params) {
+		return msg.Params[index], true
+	}
+	return "", false
+}
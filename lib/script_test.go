@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseScript(t *testing.T) {
+	steps, err := ParseScript([]string{
+		"NICK dog",
+		"!expect 001 5s",
+		"!capture chan 1 /^:\\S+ JOIN (#.*)/ 1m",
+		"!expect-not /ERROR :closing link/ 500ms",
+		"!exit 3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ScriptStep{
+		{Text: "NICK dog"},
+		{Text: "!expect 001 5s", Directive: "expect", Pattern: "001", Args: []string{"5s"}},
+		{Text: "!capture chan 1 /^:\\S+ JOIN (#.*)/ 1m", Directive: "capture", Pattern: "/^:\\S+ JOIN (#.*)/", Args: []string{"chan", "1", "1m"}},
+		{Text: "!expect-not /ERROR :closing link/ 500ms", Directive: "expect-not", Pattern: "/ERROR :closing link/", Args: []string{"500ms"}},
+		{Text: "!exit 3", Directive: "exit", Args: []string{"3"}},
+	}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, steps)
+	}
+
+	for _, invalid := range []string{"!bark", "!expect", "!expect-not 001", "!sleep forever", "!expect /(/", "!capture x! 1 001", "!capture x y 001"} {
+		if _, err := ParseScript([]string{invalid}); err == nil {
+			t.Errorf("accepted invalid script line %s", invalid)
+		}
+	}
+}
+
+func TestScriptRunner(t *testing.T) {
+	steps, err := ParseScript([]string{
+		"!expect /^:\\S+ 001 (?P<nick>\\S+)/",
+		"!capture network -1 005",
+		"PRIVMSG ${nick} :welcome to ${network}",
+		"!expect-not KICK 10ms",
+		"!expect PONG 10ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	runner := NewScriptRunner(steps, func(line string) error {
+		sent = append(sent, line)
+		return nil
+	})
+	runner.Deliver(":irc.example.com 001 dog :Welcome")
+	runner.Deliver(":irc.example.com 005 dog :ExampleNet")
+	runner.Deliver(":irc.example.com NOTICE dog :hi")
+	_, _, err = runner.Run()
+	var failure *ScriptFailure
+	if !errors.As(err, &failure) || failure.Step.Text != "!expect PONG 10ms" {
+		t.Fatalf("expected the final expectation to fail, got %v", err)
+	}
+	if len(failure.Recent) != 3 {
+		t.Errorf("bad failure report: %s", failure.Report())
+	}
+	assertLines(t, sent, "PRIVMSG dog :welcome to ExampleNet")
+
+	steps, _ = ParseScript([]string{"!expect-not KICK 1s"})
+	runner = NewScriptRunner(steps, nil)
+	runner.Deliver(":dog!u@h KICK #chan dog :bye")
+	if _, _, err = runner.Run(); !errors.As(err, &failure) {
+		t.Errorf("expected !expect-not to fail")
+	}
+
+	steps, _ = ParseScript([]string{"!exit 4"})
+	if status, exited, err := NewScriptRunner(steps, nil).Run(); !(status == 4 && exited && err == nil) {
+		t.Errorf("bad !exit result")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ergochat/irc-go/ircreader"
 )
@@ -30,36 +31,71 @@ type Socket struct {
 
 	reader ircreader.Reader
 
+	// if nonzero, GetLine and SendLine fail after these timeouts
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
 	writeMutex sync.Mutex
 	closeOnce  sync.Once
 }
 
-// ConnectSocket connects to the given host/port and starts our receivers if appropriate.
-func ConnectSocket(host string, port int, useTLS bool, tlsConfig *tls.Config) (*Socket, error) {
+// ConnectSocket connects to the configured host/port and starts our receivers if appropriate.
+func ConnectSocket(config ConnectionConfig) (*Socket, error) {
 	var address, proto string
-	if strings.HasPrefix(host, "/") {
-		address = host
+	if strings.HasPrefix(config.Host, "/") {
+		address = config.Host
 		proto = "unix"
 	} else {
-		address = net.JoinHostPort(host, strconv.Itoa(port))
+		address = net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
 		proto = "tcp"
 	}
 
-	// initial connections
-	var conn net.Conn
-	var err error
+	conn, err := config.dial(proto, address)
+	if err != nil {
+		return nil, err
+	}
 
-	if useTLS {
-		conn, err = tls.Dial(proto, address, tlsConfig)
-	} else {
-		conn, err = net.Dial(proto, address)
+	if config.ProxyHeader != nil {
+		// the header goes directly after connecting, before any TLS handshake
+		var source net.Addr = config.ProxyHeader.Source
+		if config.ProxyHeader.Source == nil {
+			source = conn.LocalAddr()
+		}
+		destination := conn.RemoteAddr()
+		if config.Proxy != nil {
+			// the real destination is on the other side of the proxy
+			destination = nil
+		}
+		if err = writeProxyHeader(conn, config.ProxyHeader.Version, source, destination); err != nil {
+			conn.Close()
+			return nil, err
+		}
 	}
 
-	if err != nil {
-		return nil, err
+	if config.TLS {
+		// tls.Dial would infer the ServerName from the address,
+		// so we have to do that ourselves
+		tlsConfig := config.TLSConfig.Clone()
+		if tlsConfig == nil {
+			tlsConfig = new(tls.Config)
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = config.Host
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		conn.SetDeadline(deadline(config.HandshakeTimeout))
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, wrapTimeout(err, "TLS handshake", config.HandshakeTimeout)
+		}
+		conn.SetDeadline(time.Time{})
+		conn = tlsConn
 	}
 
-	return MakeSocket(conn), nil
+	result := MakeSocket(conn)
+	result.readTimeout = config.ReadTimeout
+	result.writeTimeout = config.WriteTimeout
+	return result, nil
 }
 
 // MakeSocket makes a socket from the given connection.
@@ -73,8 +109,11 @@ func MakeSocket(conn net.Conn) *Socket {
 
 // GetLine returns a single IRC line from the socket.
 func (s *Socket) GetLine() (string, error) {
+	if s.readTimeout != 0 {
+		s.connection.SetReadDeadline(deadline(s.readTimeout))
+	}
 	lineBytes, err := s.reader.ReadLine()
-	return strings.TrimRight(string(lineBytes), "\r\n"), err
+	return strings.TrimRight(string(lineBytes), "\r\n"), wrapTimeout(err, "read", s.readTimeout)
 }
 
 // SendLine sends a single IRC line to the socket
@@ -82,11 +121,19 @@ func (s *Socket) SendLine(line string) error {
 	out := make([]byte, len(line)+2)
 	copy(out, line[:])
 	copy(out[len(line):], "\r\n")
+	return s.writeRaw(out)
+}
 
+// writeRaw writes arbitrary bytes to the socket; fault injection uses it
+// to write partial lines.
+func (s *Socket) writeRaw(data []byte) error {
 	s.writeMutex.Lock()
 	defer s.writeMutex.Unlock()
-	_, err := s.connection.Write(out)
-	return err
+	if s.writeTimeout != 0 {
+		s.connection.SetWriteDeadline(deadline(s.writeTimeout))
+	}
+	_, err := s.connection.Write(data)
+	return wrapTimeout(err, "write", s.writeTimeout)
 }
 
 // Disconnect severs our connection to the server.
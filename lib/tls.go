@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a TLS version like "1.2".
+func ParseTLSVersion(version string) (uint16, error) {
+	if result, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(version), "tls")]; ok {
+		return result, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version `%s` (try 1.0, 1.1, 1.2 or 1.3)", version)
+}
+
+// ParseCipherSuites parses a comma-separated list of cipher suite names, like
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", including insecure ones. TLS 1.3
+// suites are rejected, since Go doesn't allow them to be configured.
+func ParseCipherSuites(names string) (result []uint16, err error) {
+	suites := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		var found *tls.CipherSuite
+		for _, suite := range suites {
+			if strings.EqualFold(suite.Name, name) {
+				found = suite
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("unknown cipher suite `%s`", name)
+		}
+		if len(found.SupportedVersions) == 1 && found.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("TLS 1.3 cipher suite `%s` can't be configured", name)
+		}
+		result = append(result, found.ID)
+	}
+	return result, nil
+}
+
+// LoadCertPool reads a file of PEM-encoded CA certificates.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	for input, expected := range map[string]uint16{
+		"1.0":    tls.VersionTLS10,
+		"1.2":    tls.VersionTLS12,
+		"TLS1.3": tls.VersionTLS13,
+	} {
+		if version, err := ParseTLSVersion(input); err != nil || version != expected {
+			t.Errorf("expected %x for %s, got %x (%v)", expected, input, version, err)
+		}
+	}
+	if _, err := ParseTLSVersion("3.0"); err == nil {
+		t.Errorf("expected 3.0 to be invalid")
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := ParseCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls_rsa_with_aes_128_cbc_sha")
+	if err != nil || len(suites) != 2 ||
+		suites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || suites[1] != tls.TLS_RSA_WITH_AES_128_CBC_SHA {
+		t.Errorf("bad cipher suites %v: %v", suites, err)
+	}
+	for _, invalid := range []string{"TLS_AES_128_GCM_SHA256", "TLS_NONEXISTENT", ""} {
+		if _, err := ParseCipherSuites(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+// listenTLS starts a TLS listener with a new self-signed certificate (for
+// localhost and 127.0.0.1), and returns it with a CA file trusting it.
+func listenTLS(t *testing.T, config ListenerConfig) (IRCListener, string) {
+	cert, err := GenerateSelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"irc"}}
+	ln, err := Listen("127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// complete the handshake
+			ClientCertificateFingerprint(conn)
+			t.Cleanup(conn.Disconnect)
+		}
+	}()
+	return ln, caFile
+}
+
+func TestConnectionTLSOptions(t *testing.T) {
+	ln, caFile := listenTLS(t, ListenerConfig{})
+	pool, err := LoadCertPool(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCertPool(os.DevNull); err == nil {
+		t.Errorf("expected an empty CA file to be rejected")
+	}
+
+	config := ConnectionConfig{
+		Host: "127.0.0.1",
+		Port: ln.Addr().(*net.TCPAddr).Port,
+		TLS:  true,
+		TLSConfig: &tls.Config{
+			RootCAs:    pool,
+			ServerName: "localhost",
+			MinVersion: tls.VersionTLS13,
+			NextProtos: []string{"irc"},
+		},
+	}
+	conn, err := NewConnection(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+	state := conn.(*Socket).connection.(*tls.Conn).ConnectionState()
+	if state.Version != tls.VersionTLS13 || state.NegotiatedProtocol != "irc" || state.ServerName != "localhost" {
+		t.Errorf("bad connection state: version %x, protocol %q, server name %q",
+			state.Version, state.NegotiatedProtocol, state.ServerName)
+	}
+
+	// the certificate isn't valid for other names
+	config.TLSConfig.ServerName = "irc.example.com"
+	if conn, err := NewConnection(config); err == nil {
+		conn.Disconnect()
+		t.Errorf("expected verification against irc.example.com to fail")
+	}
+}
@@ -1,23 +1,46 @@
 package lib
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
+const (
+	// TranscriptTimeFormat is used for timestamped transcripts; it is
+	// RFC 3339 in UTC, with a fixed-width fractional part and no spaces.
+	TranscriptTimeFormat = "2006-01-02T15:04:05.000000Z"
+)
+
+// Transcript records raw traffic to a file. Each line is either
+// "-> " (client to server) or "<- " (server to client), followed by the
+// raw line and \r\n. Lines injected from the console in proxy mode use
+// "=> " and "<= " instead. Lines changed by proxy rewrite rules or
+// breakpoints are recorded as received, then as sent, with "~> " and "<~ ";
+// if they were dropped instead, they are recorded again with "x> " or "<x ".
+// When there are multiple connections, the marker is preceded by the
+// connection ID in brackets, e.g. "[2] -> ". If timestamps are enabled, each
+// line is also prefixed with a TranscriptTimeFormat timestamp and a single
+// space.
 type Transcript struct {
 	sync.Mutex
-	outfile *os.File
+	outfile    *os.File
+	timestamps bool
 }
 
-func NewTranscript(filename string) (result *Transcript, err error) {
+func NewTranscript(filename string, timestamps bool) (result *Transcript, err error) {
 	outfile, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
 	if err != nil {
 		return
 	}
 	return &Transcript{
-		outfile: outfile,
+		outfile:    outfile,
+		timestamps: timestamps,
 	}, nil
 }
 
@@ -29,17 +52,147 @@ func (t *Transcript) Close() error {
 }
 
 func (t *Transcript) WriteLine(line string, isClient bool) (err error) {
+	return t.WriteConnectionLine(line, isClient, 0)
+}
+
+// WriteConnectionLine writes a line labeled with a connection ID;
+// 0 means that the line is not labeled.
+func (t *Transcript) WriteConnectionLine(line string, isClient bool, connectionID uint64) (err error) {
+	if isClient {
+		return t.write("-> ", line, connectionID)
+	}
+	return t.write("<- ", line, connectionID)
+}
+
+// WriteInjectedLine writes a line that was injected into a connection,
+// rather than sent by the client or the server.
+func (t *Transcript) WriteInjectedLine(line string, toServer bool, connectionID uint64) (err error) {
+	if toServer {
+		return t.write("=> ", line, connectionID)
+	}
+	return t.write("<= ", line, connectionID)
+}
+
+// WriteRewrittenLine writes a line that was sent in place of a received
+// line, due to a rewrite rule.
+func (t *Transcript) WriteRewrittenLine(line string, toServer bool, connectionID uint64) (err error) {
+	if toServer {
+		return t.write("~> ", line, connectionID)
+	}
+	return t.write("<~ ", line, connectionID)
+}
+
+// WriteDroppedLine writes a received line that was not passed on, due to a
+// rewrite rule or breakpoint.
+func (t *Transcript) WriteDroppedLine(line string, toServer bool, connectionID uint64) (err error) {
+	if toServer {
+		return t.write("x> ", line, connectionID)
+	}
+	return t.write("<x ", line, connectionID)
+}
+
+func (t *Transcript) write(marker, line string, connectionID uint64) (err error) {
 	if t == nil {
 		return nil
 	}
-	marker := "<- "
-	if isClient {
-		marker = "-> "
+	if connectionID != 0 {
+		marker = fmt.Sprintf("[%d] %s", connectionID, marker)
 	}
 	t.Lock()
 	defer t.Unlock()
+	if t.timestamps {
+		marker = time.Now().UTC().Format(TranscriptTimeFormat) + " " + marker
+	}
 	// XXX due to an implementation limitation of ircreader, we are effectively normalizing
 	// terminating \n to \r\n even when the \r was absent:
 	_, err = fmt.Fprintf(t.outfile, "%s%s\r\n", marker, line)
 	return
 }
+
+// TranscriptEntry is a line read back from a transcript.
+type TranscriptEntry struct {
+	// line number within the transcript file
+	LineNum int
+	// zero if the transcript has no timestamps
+	Time time.Time
+	// zero if the line is not labeled with a connection ID
+	ConnectionID uint64
+	// whether the line is in the direction of the server
+	IsClient bool
+	// whether the line was injected from the console in proxy mode
+	Injected bool
+	// whether the line was sent in place of the preceding received line, due
+	// to a rewrite rule or breakpoint in proxy mode
+	Rewritten bool
+	// whether the preceding received line was dropped, rather than sent
+	Dropped bool
+	Line    string
+}
+
+// ReadTranscript reads a transcript written by Transcript, with or without
+// timestamps. Blank lines are ignored.
+func ReadTranscript(filename string) (entries []TranscriptEntry, err error) {
+	infile, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer infile.Close()
+	reader := bufio.NewReader(infile)
+	lineNum := 0
+	for {
+		line, err := reader.ReadString('\n')
+		lineNum++
+		line = strings.TrimSuffix(line, "\n")
+		line = strings.TrimSuffix(line, "\r")
+		if line != "" {
+			entry, parseErr := parseTranscriptLine(line)
+			if parseErr != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, parseErr)
+			}
+			entry.LineNum = lineNum
+			entries = append(entries, entry)
+		}
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func parseTranscriptLine(line string) (entry TranscriptEntry, err error) {
+	if !(hasTranscriptMarker(line) || strings.HasPrefix(line, "[")) {
+		timestamp, rest, _ := strings.Cut(line, " ")
+		entry.Time, err = time.Parse(TranscriptTimeFormat, timestamp)
+		if err != nil {
+			return entry, fmt.Errorf("expected -> or <-, or a timestamp")
+		}
+		line = rest
+	}
+	if strings.HasPrefix(line, "[") {
+		label, rest, _ := strings.Cut(line, " ")
+		entry.ConnectionID, err = strconv.ParseUint(strings.Trim(label, "[]"), 10, 64)
+		if err != nil || entry.ConnectionID == 0 {
+			return entry, fmt.Errorf("invalid connection ID %s", label)
+		}
+		line = rest
+	}
+	if !hasTranscriptMarker(line) {
+		return entry, fmt.Errorf("expected -> or <-")
+	}
+	entry.IsClient = line[1] == '>'
+	entry.Injected = line[0] == '=' || line[1] == '='
+	entry.Rewritten = line[0] == '~' || line[1] == '~'
+	entry.Dropped = line[0] == 'x' || line[1] == 'x'
+	entry.Line = line[3:]
+	return
+}
+
+func hasTranscriptMarker(line string) bool {
+	for _, marker := range []string{"-> ", "<- ", "=> ", "<= ", "~> ", "<~ ", "x> ", "<x "} {
+		if strings.HasPrefix(line, marker) {
+			return true
+		}
+	}
+	return false
+}
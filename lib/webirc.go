@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// WebIRCConfig describes a WEBIRC command, with which a trusted gateway
+// tells the server the real hostname and IP address of its client:
+// https://ircv3.net/specs/extensions/webirc
+type WebIRCConfig struct {
+	Password string
+	Gateway  string
+	// the client's hostname; if empty, the IP address is used
+	Hostname string
+	IP       string
+	// flags like "secure", or key-value pairs like "local-port=6697"
+	Options []string
+}
+
+// Validate checks for mistakes that would make the server reject the line.
+func (w *WebIRCConfig) Validate() error {
+	for _, param := range []string{w.Password, w.Gateway, w.Hostname} {
+		if strings.ContainsAny(param, " \r\n") {
+			return fmt.Errorf("WEBIRC parameter `%s` must not contain spaces", param)
+		}
+	}
+	if w.Password == "" || w.Gateway == "" {
+		return fmt.Errorf("WEBIRC requires a password and a gateway name")
+	}
+	if net.ParseIP(w.IP) == nil {
+		return fmt.Errorf("invalid WEBIRC IP address `%s`", w.IP)
+	}
+	for _, option := range w.Options {
+		if option == "" || strings.ContainsAny(option, " \r\n") {
+			return fmt.Errorf("invalid WEBIRC option `%s`", option)
+		}
+	}
+	return nil
+}
+
+// ForClient returns a copy of the configuration for a client connection,
+// as a gateway would send it. Unless an IP address is configured, the
+// client's address is used; Unix socket clients are local, so they get
+// 127.0.0.1. If secure is set, the secure option is added.
+func (w WebIRCConfig) ForClient(addr net.Addr, secure bool) WebIRCConfig {
+	if w.IP == "" {
+		w.IP = "127.0.0.1"
+		if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+			w.IP = tcpAddr.IP.String()
+		}
+	}
+	if secure {
+		for _, option := range w.Options {
+			if option == "secure" {
+				return w
+			}
+		}
+		w.Options = append(w.Options[:len(w.Options):len(w.Options)], "secure")
+	}
+	return w
+}
+
+// Line returns the WEBIRC line to send before registration.
+func (w *WebIRCConfig) Line() string {
+	hostname := w.Hostname
+	if hostname == "" {
+		hostname = w.IP
+	}
+	params := []string{w.Password, w.Gateway, webIRCParam(hostname), webIRCParam(w.IP)}
+	if len(w.Options) != 0 {
+		params = append(params, strings.Join(w.Options, " "))
+	}
+	return makeLine("WEBIRC", params...)
+}
+
+// webIRCParam prefixes IPv6 addresses like ::1 with 0, since a parameter
+// can't begin with a colon.
+func webIRCParam(param string) string {
+	if strings.HasPrefix(param, ":") {
+		return "0" + param
+	}
+	return param
+}
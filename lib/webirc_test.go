@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"net"
+	"testing"
+)
+
+func TestWebIRCLine(t *testing.T) {
+	config := WebIRCConfig{Password: "hunter2", Gateway: "ircdog", IP: "203.0.113.5"}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	assertLines(t, []string{config.Line()}, "WEBIRC hunter2 ircdog 203.0.113.5 203.0.113.5")
+
+	config.Hostname = "client.example.com"
+	config.Options = []string{"secure", "local-port=6697"}
+	assertLines(t, []string{config.Line()}, "WEBIRC hunter2 ircdog client.example.com 203.0.113.5 :secure local-port=6697")
+
+	// a configured IP address takes precedence over the client's
+	client := config.ForClient(&net.TCPAddr{IP: net.ParseIP("198.51.100.1")}, false)
+	assertLines(t, []string{client.Line()}, "WEBIRC hunter2 ircdog client.example.com 203.0.113.5 :secure local-port=6697")
+
+	gateway := config
+	gateway.IP, gateway.Hostname = "", ""
+	// IPv6 addresses beginning with a colon are prefixed with 0
+	client = gateway.ForClient(&net.TCPAddr{IP: net.IPv6loopback, Port: 4000}, true)
+	assertLines(t, []string{client.Line()}, "WEBIRC hunter2 ircdog 0::1 0::1 :secure local-port=6697")
+	client = gateway.ForClient(&net.UnixAddr{Name: "@", Net: "unix"}, false)
+	if client.IP != "127.0.0.1" {
+		t.Errorf("expected Unix socket client to get 127.0.0.1, got %s", client.IP)
+	}
+
+	gateway.Options = nil
+	client = gateway.ForClient(&net.TCPAddr{IP: net.ParseIP("198.51.100.1")}, true)
+	assertLines(t, []string{client.Line()}, "WEBIRC hunter2 ircdog 198.51.100.1 198.51.100.1 secure")
+	if len(gateway.Options) != 0 {
+		t.Errorf("ForClient modified the original options")
+	}
+
+	for _, invalid := range []WebIRCConfig{
+		{Password: "hunter2", Gateway: "ircdog", IP: "example.com"},
+		{Password: "", Gateway: "ircdog", IP: "203.0.113.5"},
+		{Password: "hunter2", Gateway: "my gateway", IP: "203.0.113.5"},
+		{Password: "hunter2", Gateway: "ircdog", IP: "203.0.113.5", Options: []string{""}},
+	} {
+		if invalid.Validate() == nil {
+			t.Errorf("expected %#v to be invalid", invalid)
+		}
+	}
+
+	config.Options = nil
+	r := NewRegistrar(RegistrationConfig{Nick: "dog", WebIRC: &config})
+	assertLines(t, r.Start(), "WEBIRC hunter2 ircdog client.example.com 203.0.113.5", "NICK dog", "USER dog 0 * ircdog")
+}
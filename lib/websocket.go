@@ -3,12 +3,13 @@
 package lib
 
 import (
-	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -17,13 +18,17 @@ type IRCWebSocket struct {
 	writeMutex sync.Mutex
 	closeOnce  sync.Once
 	websocket  *websocket.Conn
+
+	// if nonzero, GetLine and SendLine fail after these timeouts
+	readTimeout  time.Duration
+	writeTimeout time.Duration
 }
 
-func NewIRCWebSocket(wsUrl, origin string, tlsConfig *tls.Config) (IRCConnection, error) {
+func NewIRCWebSocket(config ConnectionConfig) (IRCConnection, error) {
 	var headers http.Header
-	if origin != "" {
+	if config.Origin != "" {
 		headers = make(http.Header)
-		u, err := url.Parse(origin)
+		u, err := url.Parse(config.Origin)
 		if err != nil {
 			return nil, err
 		}
@@ -34,11 +39,17 @@ func NewIRCWebSocket(wsUrl, origin string, tlsConfig *tls.Config) (IRCConnection
 	}
 
 	dialer := websocket.Dialer{
-		Subprotocols:    []string{"text.ircv3.net", "binary.ircv3.net"},
-		TLSClientConfig: tlsConfig,
+		Subprotocols:     []string{"text.ircv3.net", "binary.ircv3.net"},
+		TLSClientConfig:  config.TLSConfig,
+		NetDial:          config.dial,
+		HandshakeTimeout: config.HandshakeTimeout,
 	}
-	ws, resp, err := dialer.Dial(wsUrl, headers)
+	ws, resp, err := dialer.Dial(config.WebsocketURL, headers)
 	if err != nil {
+		var timeout *TimeoutError
+		if !errors.As(err, &timeout) {
+			err = wrapTimeout(err, "WebSocket handshake", config.HandshakeTimeout)
+		}
 		explanation := "no HTTP response"
 		if resp != nil {
 			explanation = fmt.Sprintf("HTTP status code %d", resp.StatusCode)
@@ -46,13 +57,18 @@ func NewIRCWebSocket(wsUrl, origin string, tlsConfig *tls.Config) (IRCConnection
 		return nil, fmt.Errorf("%w (%s)", err, explanation)
 	}
 	return &IRCWebSocket{
-		websocket: ws,
+		websocket:    ws,
+		readTimeout:  config.ReadTimeout,
+		writeTimeout: config.WriteTimeout,
 	}, nil
 }
 
 func (w *IRCWebSocket) GetLine() (string, error) {
+	if w.readTimeout != 0 {
+		w.websocket.SetReadDeadline(deadline(w.readTimeout))
+	}
 	_, lineBytes, err := w.websocket.ReadMessage()
-	return string(lineBytes), err
+	return string(lineBytes), wrapTimeout(err, "read", w.readTimeout)
 }
 
 func (w *IRCWebSocket) SendLine(line string) error {
@@ -62,7 +78,10 @@ func (w *IRCWebSocket) SendLine(line string) error {
 	}
 	w.writeMutex.Lock()
 	defer w.writeMutex.Unlock()
-	return w.websocket.WriteMessage(messageType, []byte(line))
+	if w.writeTimeout != 0 {
+		w.websocket.SetWriteDeadline(deadline(w.writeTimeout))
+	}
+	return wrapTimeout(w.websocket.WriteMessage(messageType, []byte(line)), "write", w.writeTimeout)
 }
 
 func (w *IRCWebSocket) Disconnect() {
@@ -76,3 +95,88 @@ func (w *IRCWebSocket) realDisconnect() {
 func (w *IRCWebSocket) RemoteAddr() net.Addr {
 	return w.websocket.RemoteAddr()
 }
+
+func (w *IRCWebSocket) underlyingConn() net.Conn {
+	return w.websocket.UnderlyingConn()
+}
+
+// webSocketListener accepts IRC-over-WebSocket connections from clients,
+// as a WebSocket gateway in front of an ircd would.
+type webSocketListener struct {
+	ln       net.Listener
+	server   *http.Server
+	upgrader websocket.Upgrader
+	config   ListenerConfig
+
+	conns     chan IRCConnection
+	serveErr  chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newWebSocketListener(ln net.Listener, config ListenerConfig) (IRCListener, error) {
+	result := &webSocketListener{
+		ln:     ln,
+		config: config,
+		upgrader: websocket.Upgrader{
+			Subprotocols: []string{"text.ircv3.net", "binary.ircv3.net"},
+			// we check the Origin ourselves, so we can log the result
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		conns:    make(chan IRCConnection),
+		serveErr: make(chan error, 1),
+		closed:   make(chan struct{}),
+	}
+	result.server = &http.Server{Handler: result}
+	go func() {
+		result.serveErr <- result.server.Serve(ln)
+	}()
+	return result, nil
+}
+
+func (l *webSocketListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if l.config.Verbose {
+		l.config.log(fmt.Sprintf("WebSocket handshake from %s: %s %s, Origin %q, User-Agent %q, subprotocols %q",
+			r.RemoteAddr, r.Method, r.URL.RequestURI(), origin, r.UserAgent(), websocket.Subprotocols(r)))
+	}
+	if !OriginAllowed(origin, l.config.AllowedOrigins) {
+		l.config.log(fmt.Sprintf("rejected WebSocket handshake from %s: Origin %q is not allowed", r.RemoteAddr, origin))
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	ws, err := l.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already sent an HTTP error response
+		l.config.log(fmt.Sprintf("WebSocket handshake from %s failed: %v", r.RemoteAddr, err))
+		return
+	}
+	if l.config.Verbose {
+		l.config.log(fmt.Sprintf("WebSocket handshake from %s succeeded, with subprotocol %q", r.RemoteAddr, ws.Subprotocol()))
+	}
+	select {
+	case l.conns <- &IRCWebSocket{websocket: ws}:
+	case <-l.closed:
+		ws.Close()
+	}
+}
+
+func (l *webSocketListener) Accept() (IRCConnection, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.serveErr:
+		// make the error available to subsequent calls
+		l.serveErr <- err
+		return nil, err
+	}
+}
+
+func (l *webSocketListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.server.Close()
+}
+
+func (l *webSocketListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
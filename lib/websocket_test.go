@@ -0,0 +1,95 @@
+//go:build !minimal
+
+package lib
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestListenWebSocket(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0", ListenerConfig{
+		WebSocket:      true,
+		AllowedOrigins: []string{"https://example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	url := "ws://" + ln.Addr().String() + "/webirc"
+	dialer := websocket.Dialer{Subprotocols: []string{"text.ircv3.net"}}
+
+	_, resp, err := dialer.Dial(url, http.Header{"Origin": []string{"https://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected disallowed origin to be rejected, got %v", err)
+	}
+
+	ws, resp, err := dialer.Dial(url, http.Header{"Origin": []string{"https://example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if protocol := resp.Header.Get("Sec-WebSocket-Protocol"); protocol != "text.ircv3.net" {
+		t.Errorf("expected text.ircv3.net subprotocol, got %q", protocol)
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("PING x")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := conn.GetLine()
+	if err != nil || line != "PING x" {
+		t.Errorf("bad line %q: %v", line, err)
+	}
+	if err := conn.SendLine("PONG x"); err != nil {
+		t.Fatal(err)
+	}
+	_, message, err := ws.ReadMessage()
+	if err != nil || string(message) != "PONG x" {
+		t.Errorf("bad message %q: %v", message, err)
+	}
+}
+
+func TestWebSocketHandshakeTimeout(t *testing.T) {
+	addr := listenSilently(t)
+	_, err := NewIRCWebSocket(ConnectionConfig{
+		WebsocketURL:     "ws://" + addr.String() + "/webirc",
+		HandshakeTimeout: 100 * time.Millisecond,
+	})
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) || timeout.Operation != "WebSocket handshake" {
+		t.Errorf("expected WebSocket handshake timeout, got %v", err)
+	}
+}
+
+func TestWebSocketTLSOptions(t *testing.T) {
+	ln, caFile := listenTLS(t, ListenerConfig{WebSocket: true})
+	pool, err := LoadCertPool(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := ConnectionConfig{
+		WebsocketURL: "wss://" + ln.Addr().String() + "/webirc",
+		TLSConfig:    &tls.Config{RootCAs: pool, ServerName: "localhost"},
+	}
+	conn, err := NewIRCWebSocket(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Disconnect()
+
+	config.TLSConfig.ServerName = "irc.example.com"
+	if conn, err := NewIRCWebSocket(config); err == nil {
+		conn.Disconnect()
+		t.Errorf("expected verification against irc.example.com to fail")
+	}
+}
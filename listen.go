@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ergochat/ircdog/lib"
+)
+
+// parseListenerConfig parses the options for accepting clients, in --listen,
+// serve or replay mode.
+func parseListenerConfig(arguments map[string]any) (config lib.ListenerConfig, err error) {
+	certFile, _ := arguments["--listen-cert"].(string)
+	keyFile, _ := arguments["--listen-key"].(string)
+	requestCert := arguments["--request-client-cert"].(bool)
+	if arguments["--listen-tls"].(bool) {
+		config.TLSConfig, err = makeListenerTLSConfig(certFile, keyFile, requestCert)
+		if err != nil {
+			return
+		}
+	} else if certFile != "" || keyFile != "" || requestCert {
+		err = fmt.Errorf("--listen-cert, --listen-key and --request-client-cert require --listen-tls")
+		return
+	}
+
+	if mode := arguments["--listen-mode"]; mode != nil {
+		value, parseErr := strconv.ParseUint(mode.(string), 8, 32)
+		if parseErr != nil || value == 0 || value > 0777 {
+			return config, fmt.Errorf("Invalid --listen-mode: %s (expected permissions in octal, like 0660)", mode)
+		}
+		config.UnixSocketMode = os.FileMode(value)
+	}
+
+	config.Log = func(message string) {
+		log.Printf("** ircdog %s", message)
+	}
+	config.ProxyProtocol = arguments["--listen-proxy-protocol"].(bool)
+
+	if arguments["--listen-websocket"].(bool) {
+		config.WebSocket = true
+		config.Verbose = arguments["--verbose"].(bool)
+		if origins := arguments["--allow-origin"]; origins != nil {
+			config.AllowedOrigins = strings.Split(origins.(string), ",")
+		}
+	} else if arguments["--allow-origin"] != nil {
+		err = fmt.Errorf("--allow-origin requires --listen-websocket")
+	}
+	return
+}
+
+// makeListenerTLSConfig loads or generates the certificate for --listen-tls.
+func makeListenerTLSConfig(certFile, keyFile string, requestCert bool) (config *tls.Config, err error) {
+	var cert tls.Certificate
+	if certFile != "" {
+		if keyFile == "" {
+			// like --client-cert, the file can contain both
+			keyFile = certFile
+		}
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load TLS listener cert/key: %w", err)
+		}
+	} else if keyFile != "" {
+		return nil, fmt.Errorf("--listen-key requires --listen-cert")
+	} else {
+		cert, err = lib.GenerateSelfSignedCertificate()
+		if err != nil {
+			return nil, fmt.Errorf("Cannot generate TLS listener cert: %w", err)
+		}
+		log.Printf("** ircdog generated a self-signed certificate with SHA-256 fingerprint %s", lib.CertificateFingerprint(cert.Certificate[0]))
+	}
+	config = new(tls.Config)
+	config.Certificates = []tls.Certificate{cert}
+	if requestCert {
+		// we only display the certificate, so any certificate is accepted
+		config.ClientAuth = tls.RequestClientCert
+	}
+	return
+}
+
+// openListener opens the listener for --listen, serve or replay mode, logging any error.
+func openListener(listenAddress string, config lib.ListenerConfig) (ln lib.IRCListener, err error) {
+	ln, err = lib.Listen(listenAddress, config)
+	if err != nil {
+		log.Println("** ircdog could not open listener:", err.Error())
+		log.Println("Listener should have the form [host]:<port> like localhost:6667 or :8889, or unix:/path/to/socket")
+	}
+	return
+}
+
+// checkClientTLS completes the TLS handshake with a newly accepted client,
+// if applicable, and displays its certificate fingerprint; it returns false
+// if the handshake failed.
+func checkClientTLS(connectionID uint64, conn lib.IRCConnection) bool {
+	name := "client"
+	if connectionID != 0 {
+		name = fmt.Sprintf("client %d", connectionID)
+	}
+	fingerprint, err := lib.ClientCertificateFingerprint(conn)
+	if err != nil {
+		log.Printf("** ircdog TLS handshake with %s failed: %v", name, err)
+		conn.Disconnect()
+		return false
+	}
+	if fingerprint != "" {
+		log.Printf("** ircdog %s presented a certificate with SHA-256 fingerprint %s", name, fingerprint)
+	}
+	return true
+}
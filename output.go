@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircfmt"
+	"github.com/ergochat/irc-go/ircmsg"
+
+	"github.com/ergochat/ircdog/lib"
+)
+
+// lineFormatter renders lines of IRC traffic for display, either for the
+// terminal or as JSON.
+type lineFormatter struct {
+	raw        bool
+	escape     bool
+	useItalics bool
+	colorLevel lib.ColorLevel
+	json       bool
+	// if set, a time.Format layout for prefixing lines with the local time
+	timestampFormat string
+}
+
+// render formats a line of traffic received by ircdog, interpreting its
+// formatting codes unless --raw or --escape was passed. marker, if present,
+// is displayed before the line (but after the timestamp and the connection
+// label, if connectionID is nonzero).
+func (f *lineFormatter) render(line, marker, direction string, connectionID uint64) string {
+	if f.json {
+		return string(lib.MakeJSONLine(line, direction, connectionID, time.Now()))
+	}
+	if f.escape {
+		line = ircfmt.Escape(line)
+	} else if !f.raw {
+		if _, err := ircmsg.ParseLine(line); err == nil {
+			line = lib.IRCLineToAnsi(line, f.colorLevel, f.useItalics)
+		}
+	}
+	return f.timestamp() + f.connectionLabel(connectionID) + marker + line
+}
+
+// echo formats a line sent by ircdog (e.g. an automatic PONG), which is
+// displayed as-is.
+func (f *lineFormatter) echo(line string) string {
+	if f.json {
+		return string(lib.MakeJSONLine(line, lib.DirectionOut, 0, time.Now()))
+	}
+	return f.timestamp() + line
+}
+
+// markers returns the indicators for whether a line is going from client
+// to server, or vice versa.
+func (f *lineFormatter) markers() (c2s, s2c string) {
+	return f.pickMarkers(c2sMarkerPlain, s2cMarkerPlain, c2sMarkerColor, s2cMarkerColor)
+}
+
+// injectedMarkers is like markers, but for lines injected from the console
+// in proxy mode.
+func (f *lineFormatter) injectedMarkers() (c2s, s2c string) {
+	return f.pickMarkers(c2sInjectedMarkerPlain, s2cInjectedMarkerPlain, c2sInjectedMarkerColor, s2cInjectedMarkerColor)
+}
+
+// rewrittenMarkers is like markers, but for lines sent in place of
+// received lines, due to proxy rewrite rules.
+func (f *lineFormatter) rewrittenMarkers() (c2s, s2c string) {
+	return f.pickMarkers(c2sRewrittenMarkerPlain, s2cRewrittenMarkerPlain, c2sRewrittenMarkerColor, s2cRewrittenMarkerColor)
+}
+
+func (f *lineFormatter) pickMarkers(c2sPlain, s2cPlain, c2sColor, s2cColor string) (c2s, s2c string) {
+	if f.json {
+		// the direction is part of the JSON object
+		return "", ""
+	}
+	if f.raw || f.escape || f.colorLevel == lib.ColorLevelNone {
+		return c2sPlain, s2cPlain
+	}
+	return c2sColor, s2cColor
+}
+
+// connectionLabel returns a short label for a connection, colored so that
+// interleaved connections can be told apart at a glance.
+func (f *lineFormatter) connectionLabel(connectionID uint64) string {
+	if connectionID == 0 {
+		return ""
+	}
+	label := fmt.Sprintf("[%d]", connectionID)
+	if f.raw || f.escape || f.colorLevel == lib.ColorLevelNone {
+		return label
+	}
+	color := connectionColors[(connectionID-1)%uint64(len(connectionColors))]
+	return "\x1b[" + color + "m" + label + "\x1b[0m"
+}
+
+// ANSI foreground colors for connection labels; red and green are
+// avoided, since they're used for the direction markers
+var connectionColors = []string{"33", "34", "35", "36", "93", "94", "95", "96"}
+
+func (f *lineFormatter) timestamp() string {
+	if f.timestampFormat == "" {
+		return ""
+	}
+	return time.Now().Format(f.timestampFormat) + " "
+}
+
+// trafficPrinter prints lines of traffic, skipping hidden commands.
+// It is safe for concurrent use by multiple connections.
+type trafficPrinter struct {
+	formatter      *lineFormatter
+	hiddenCommands map[string]bool
+	// if nil, os.Stdout
+	out io.Writer
+
+	mutex sync.Mutex
+}
+
+func (p *trafficPrinter) print(line, marker, direction string, connectionID uint64) {
+	if msg, err := ircmsg.ParseLine(line); err == nil && p.hiddenCommands[msg.Command] {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.out != nil {
+		fmt.Fprintln(p.out, p.formatter.render(line, marker, direction, connectionID))
+	} else {
+		fmt.Println(p.formatter.render(line, marker, direction, connectionID))
+	}
+}
+
+// jsonLogWriter converts ircdog's own log messages into JSON events,
+// so they can be interleaved with the traffic in --format=json.
+type jsonLogWriter struct {
+	out io.Writer
+}
+
+func (w *jsonLogWriter) Write(p []byte) (n int, err error) {
+	message := strings.TrimSuffix(string(p), "\n")
+	message = strings.TrimPrefix(message, "** ircdog ")
+	event := lib.MakeJSONEvent(message, 0, time.Now())
+	_, err = w.out.Write(append(event, '\n'))
+	return len(p), err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/ergochat/ircdog/lib"
+)
+
+// runReplay implements `ircdog replay`: it accepts a single client and plays
+// back the server side of a transcript to it.
+func runReplay(
+	transcriptFile, listenAddress string, listenerConfig lib.ListenerConfig, match string,
+	hiddenCommands map[string]bool, transcript *lib.Transcript,
+	formatter *lineFormatter) int {
+
+	entries, err := lib.ReadTranscript(transcriptFile)
+	if err != nil {
+		log.Printf("** ircdog could not read transcript to replay: %v", err)
+		return 1
+	}
+
+	ln, err := openListener(listenAddress, listenerConfig)
+	if err != nil {
+		return 1
+	}
+	log.Printf("** ircdog replaying %d lines on %s", len(entries), listenAddress)
+	client, err := ln.Accept()
+	ln.Close()
+	if err != nil {
+		log.Printf("** ircdog could not accept incoming connection from listener: %v", err)
+		return 1
+	}
+	log.Printf("** ircdog accepted connection from %s", client.RemoteAddr().String())
+	if !checkClientTLS(0, client) {
+		return 1
+	}
+	defer client.Disconnect()
+
+	printer := &trafficPrinter{formatter: formatter, hiddenCommands: hiddenCommands}
+	c2sMarker, s2cMarker := formatter.markers()
+	session := lib.NewReplaySession(entries, match)
+	mismatches := 0
+
+	sendServerLines := func() bool {
+		for _, line := range session.ServerLines() {
+			err := client.SendLine(line)
+			transcript.WriteLine(line, false)
+			printer.print(line, s2cMarker, lib.DirectionIn, 0)
+			if err != nil {
+				log.Printf("** ircdog couldn't send line to client: %v", err)
+				return false
+			}
+		}
+		if session.Done() {
+			log.Printf("** ircdog reached the end of the transcript")
+		}
+		return true
+	}
+
+	if !sendServerLines() {
+		return 1
+	}
+	for {
+		line, err := client.GetLine()
+		if line != "" || err == nil {
+			transcript.WriteLine(line, true)
+		}
+		if err != nil {
+			log.Printf("** ircdog client disconnected: %v", err)
+			break
+		}
+		printer.print(line, c2sMarker, lib.DirectionOut, 0)
+
+		wasDone := session.Done()
+		if mismatch := session.HandleClientLine(line); mismatch != "" {
+			mismatches++
+			log.Printf("** ircdog replay %s", strings.ReplaceAll(mismatch, "\n", "\n  "))
+		}
+		if !wasDone && !sendServerLines() {
+			break
+		}
+	}
+
+	if !session.Done() {
+		log.Printf("** ircdog client disconnected before the end of the transcript")
+		mismatches++
+	}
+	if mismatches != 0 {
+		log.Printf("** ircdog replay finished with %d mismatches", mismatches)
+		return exitStatusReplayMismatch
+	}
+	return 0
+}
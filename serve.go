@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/ergochat/ircdog/lib"
+)
+
+// mockServer implements `ircdog serve`, answering clients itself
+// instead of proxying them to a real server.
+type mockServer struct {
+	ln         lib.IRCListener
+	serverName string
+	rules      []lib.MockServerRule
+	transcript *lib.Transcript
+	formatter  *lineFormatter
+	printer    *trafficPrinter
+}
+
+func runMockServer(
+	listenAddress string, listenerConfig lib.ListenerConfig, rulesFile, serverName string,
+	hiddenCommands map[string]bool, transcript *lib.Transcript,
+	formatter *lineFormatter) int {
+
+	var rules []lib.MockServerRule
+	if rulesFile != "" {
+		var err error
+		rules, err = lib.ReadMockServerRules(rulesFile)
+		if err != nil {
+			log.Printf("** ircdog could not read rules file: %v", err)
+			return 1
+		}
+	}
+
+	ln, err := openListener(listenAddress, listenerConfig)
+	if err != nil {
+		return 1
+	}
+
+	log.Printf("** ircdog mock server listening on %s with %d rules", listenAddress, len(rules))
+
+	server := mockServer{
+		ln:         ln,
+		serverName: serverName,
+		rules:      rules,
+		transcript: transcript,
+		formatter:  formatter,
+		printer:    &trafficPrinter{formatter: formatter, hiddenCommands: hiddenCommands},
+	}
+	return server.acceptLoop()
+}
+
+func (s *mockServer) acceptLoop() int {
+	var connectionCounter uint64
+	for {
+		client, err := s.ln.Accept()
+		if err != nil {
+			log.Printf("** ircdog could not accept incoming connection from listener: %v", err)
+			return 1
+		}
+		connectionCounter++
+		go s.serve(connectionCounter, client)
+	}
+}
+
+func (s *mockServer) serve(connectionID uint64, client lib.IRCConnection) {
+	log.Printf("** ircdog accepted connection %d from %s", connectionID, client.RemoteAddr().String())
+	if !checkClientTLS(connectionID, client) {
+		return
+	}
+	defer client.Disconnect()
+
+	session := lib.NewMockServerSession(s.serverName, s.rules)
+	c2sMarker, s2cMarker := s.formatter.markers()
+
+	for {
+		line, err := client.GetLine()
+		if line != "" || err == nil {
+			s.transcript.WriteConnectionLine(line, true, connectionID)
+		}
+		if err != nil {
+			log.Printf("** ircdog client %d disconnected: %v", connectionID, err)
+			return
+		}
+		s.printer.print(line, c2sMarker, lib.DirectionOut, connectionID)
+
+		for _, action := range session.HandleLine(line) {
+			if action.Delay != 0 {
+				time.Sleep(action.Delay)
+			}
+			if action.Line != "" {
+				err = client.SendLine(action.Line)
+				s.transcript.WriteConnectionLine(action.Line, false, connectionID)
+				s.printer.print(action.Line, s2cMarker, lib.DirectionIn, connectionID)
+				if err != nil {
+					log.Printf("** ircdog couldn't send line to client %d: %v", connectionID, err)
+					return
+				}
+			}
+			if action.Disconnect {
+				log.Printf("** ircdog disconnecting client %d", connectionID)
+				return
+			}
+		}
+	}
+}